@@ -0,0 +1,736 @@
+package influx
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Errors returned by Unmarshal and Decoder, use errors.Is to check them.
+var (
+	ErrSyntax         = errors.New("line protocol syntax error")
+	ErrInvalidTarget  = errors.New("unmarshal target must be a non-nil pointer to struct")
+	ErrUnmarshalValue = errors.New("cannot unmarshal value")
+)
+
+// ValueUnmarshaler is implemented by the types which decode themselves from the typed
+// value of tag or field, it is the counterpart of ValueMarshaler used by Unmarshal.
+// The tag values are passed as strings.
+type ValueUnmarshaler interface {
+	UnmarshalInfluxValue(Value) error
+}
+
+var (
+	valueUnmarshalerType = reflect.TypeFor[ValueUnmarshaler]()
+	textUnmarshalerType  = reflect.TypeFor[encoding.TextUnmarshaler]()
+)
+
+// pair is the key and value of tag or field of parsed line.
+type pair struct {
+	key string
+	val string
+	// quoted is set for string field values, the val holds the unquoted string.
+	quoted bool
+}
+
+// line is a parsed line protocol row, all its elements are unescaped.
+type line struct {
+	measurement  string
+	tags         []pair
+	fields       []pair
+	timestamp    int64
+	hasTimestamp bool
+}
+
+// scanTo returns the index of the first unescaped byte of s from stops
+// starting from i, or len(s) if there is no such byte.
+func scanTo(s string, i int, stops string) int {
+	for ; i < len(s); i++ {
+		if s[i] == '\\' {
+			i++
+			continue
+		}
+		if strings.IndexByte(stops, s[i]) >= 0 {
+			return i
+		}
+	}
+	return len(s)
+}
+
+// unescape removes the backslashes put by appendMeasurement or appendKey
+// in front of chars and backslashes, the other backslashes are kept as is.
+func unescape(s string, chars string) string {
+	if strings.IndexByte(s, '\\') == -1 {
+		return s
+	}
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && (s[i+1] == '\\' || strings.IndexByte(chars, s[i+1]) >= 0) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// unescapeKey reverts appendKey.
+func unescapeKey(s string) string { return unescape(s, ",= ") }
+
+// unescapeFieldV reverts appendFieldV, s is the quoted string value.
+func unescapeFieldV(s string) string { return unescape(s[1:len(s)-1], `"`) }
+
+// errUnterminatedString is the syntax error of row which string field value is not
+// terminated, it may continue on the next line.
+var errUnterminatedString = errors.New("unterminated string")
+
+func syntaxError(msg string, pos int) error {
+	return fmt.Errorf("%w: %s at position %d", ErrSyntax, msg, pos)
+}
+
+// parseLine parses the line protocol row.
+func parseLine(b []byte) (l line, err error) {
+	s := strings.TrimRight(string(b), "\r\n")
+
+	// measurement
+	i := scanTo(s, 0, ", ")
+	l.measurement = unescape(s[:i], ", ")
+	if l.measurement == "" {
+		return l, syntaxError("missing measurement", 0)
+	}
+
+	// tags
+	for i < len(s) && s[i] == ',' {
+		i++
+		j := scanTo(s, i, ",= ")
+		if j == len(s) || s[j] != '=' {
+			return l, syntaxError("missing tag value", j)
+		}
+		key := unescapeKey(s[i:j])
+		i = j + 1
+		j = scanTo(s, i, ", ")
+		l.tags = append(l.tags, pair{key: key, val: unescapeKey(s[i:j])})
+		i = j
+	}
+
+	// fields
+	if i == len(s) {
+		return l, syntaxError("missing fields", i)
+	}
+	for sep := byte(' '); i < len(s) && s[i] == sep; sep = ',' {
+		i++
+		j := scanTo(s, i, ",= ")
+		if j == len(s) || s[j] != '=' {
+			return l, syntaxError("missing field value", j)
+		}
+		p := pair{key: unescapeKey(s[i:j])}
+		i = j + 1
+
+		if i < len(s) && s[i] == '"' {
+			j = scanTo(s, i+1, `"`)
+			if j == len(s) {
+				return l, fmt.Errorf("%w: %w at position %d", ErrSyntax, errUnterminatedString, i)
+			}
+			j++
+			p.val, p.quoted = unescapeFieldV(s[i:j]), true
+		} else {
+			j = scanTo(s, i, ", ")
+			p.val = s[i:j]
+		}
+		if p.key == "" || (!p.quoted && p.val == "") {
+			return l, syntaxError("invalid field", i)
+		}
+		l.fields = append(l.fields, p)
+		i = j
+	}
+
+	// timestamp
+	if i < len(s) {
+		if s[i] != ' ' {
+			return l, syntaxError("unexpected character", i)
+		}
+		if l.timestamp, err = strconv.ParseInt(s[i+1:], 10, 64); err != nil {
+			return l, syntaxError("invalid timestamp", i+1)
+		}
+		l.hasTimestamp = true
+	}
+
+	return l, nil
+}
+
+// appendTo appends the line protocol row of parsed line to dst.
+func (l *line) appendTo(dst []byte) []byte {
+	dst = appendMeasurement(dst, l.measurement)
+	for _, t := range l.tags {
+		dst = append(dst, ',')
+		dst = appendKey(dst, t.key)
+		dst = append(dst, '=')
+		dst = appendKey(dst, t.val)
+	}
+	for i, f := range l.fields {
+		if i == 0 {
+			dst = append(dst, ' ')
+		} else {
+			dst = append(dst, ',')
+		}
+		dst = appendKey(dst, f.key)
+		dst = append(dst, '=')
+		if f.quoted {
+			dst = appendFieldV(dst, f.val)
+		} else {
+			dst = append(dst, f.val...)
+		}
+	}
+	if l.hasTimestamp {
+		dst = append(dst, ' ')
+		dst = strconv.AppendInt(dst, l.timestamp, 10)
+	}
+	return dst
+}
+
+// Canonicalize normalizes the line protocol row, so the rows describing the same point
+// become equal: the tags and fields are sorted by keys, the escaping is made the same
+// as of Marshal and the boolean values are written as true or false.
+func Canonicalize(data []byte) ([]byte, error) {
+	l, err := parseLine(data)
+	if err != nil {
+		return nil, err
+	}
+
+	byKey := func(a, b pair) int { return strings.Compare(a.key, b.key) }
+	slices.SortStableFunc(l.tags, byKey)
+	slices.SortStableFunc(l.fields, byKey)
+
+	for i, f := range l.fields {
+		if f.quoted {
+			continue
+		}
+		switch f.val {
+		case "t", "T", "True", "TRUE":
+			l.fields[i].val = "true"
+		case "f", "F", "False", "FALSE":
+			l.fields[i].val = "false"
+		}
+	}
+
+	return l.appendTo(make([]byte, 0, len(data))), nil
+}
+
+// Unmarshal parses the influxdb line protocol row and stores the result in the
+// struct pointed to by v.
+//
+// The struct tags are the same as for Marshal: the measurement, tags, fields and
+// timestamp of line are stored to the struct fields having the same names and types,
+// the elements of line which have no corresponding struct fields are ignored.
+// Tag values may be stored to string fields or parsed to numbers and booleans
+// like the field values. The timestamp is read in the precision set by the option
+// of struct tag, e.g. `influx:",timestamp,ms"`, nanoseconds by default. The values
+// of fields having type options, e.g. `influx:"count,field,int"`, are converted
+// back to the types of fields, ErrLossyConversion is returned if this loses data.
+// The timestamp fields of types implementing Timestamper are skipped, there is
+// no way to set them from the time.
+// The types implementing ValueUnmarshaler decode themselves, the string values
+// are stored to the types implementing encoding.TextUnmarshaler (e.g. net.IP)
+// with UnmarshalText method. The tags and fields which can't be stored, e.g.
+// the types having MarshalInflux method only, are skipped, the rest of line is
+// stored and the errors are joined to the returned error.
+// Like in encoding/json, the nil pointers to unexported embedded structs can't
+// be allocated, their fields are skipped.
+func Unmarshal(data []byte, v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return ErrInvalidTarget
+	}
+
+	l, err := parseLine(data)
+	if err != nil {
+		return err
+	}
+	p, err := cachedTypePlan(rv.Elem().Type())
+	if err != nil {
+		return err
+	}
+	return p.setLine(rv.Elem(), l)
+}
+
+// setLine stores the elements of line to struct value v. The elements which
+// can't be stored are skipped, their errors are joined to the returned error.
+func (p *typePlan) setLine(v reflect.Value, l line) error {
+	var errs []error
+	for i := range p.fields {
+		f := &p.fields[i]
+		fv, ok := fieldByIndexAlloc(v, f.index)
+		if !ok {
+			continue // the fields of nil unexported embedded structs are skipped
+		}
+
+		switch f.kind {
+		case kindMeasurement:
+			switch fv = indirectAlloc(fv); fv.Kind() {
+			case reflect.String:
+				fv.SetString(l.measurement)
+			case reflect.Interface:
+				fv.Set(reflect.ValueOf(l.measurement))
+			default:
+				errs = append(errs, fmt.Errorf("%w: measurement into %s", ErrUnmarshalValue, fv.Type()))
+			}
+		case kindTimestamp:
+			if !l.hasTimestamp || f.timestamper != noMethod {
+				continue // the timestamp of Timestamper can't be set
+			}
+			if !f.setTimestamp(fv, unixTime(l.timestamp, f.precision)) {
+				errs = append(errs, fmt.Errorf("%w: timestamp into %s", ErrUnmarshalValue, fv.Type()))
+			}
+		case kindTag:
+			for _, t := range l.tags {
+				if t.key == f.key {
+					if err := f.setValue(fv, pair{val: t.val, quoted: isStringType(fv.Type()) || f.coerce == StringValue}); err != nil {
+						errs = append(errs, fmt.Errorf("tag %q: %w", f.key, err))
+					}
+				}
+			}
+		case kindField:
+			for _, fl := range l.fields {
+				if fl.key == f.key {
+					if err := f.setValue(fv, fl); err != nil {
+						errs = append(errs, fmt.Errorf("field %q: %w", f.key, err))
+					}
+				}
+			}
+		case kindTags:
+			for _, t := range l.tags {
+				if strings.HasPrefix(t.key, f.key) && !p.hasKey(kindTag, t.key) {
+					err := f.setMapValue(fv, t.key[len(f.key):], pair{val: t.val, quoted: isStringElem(fv) || f.coerce == StringValue})
+					if err != nil {
+						errs = append(errs, fmt.Errorf("tag %q: %w", t.key, err))
+					}
+				}
+			}
+		case kindFields:
+			for _, fl := range l.fields {
+				if strings.HasPrefix(fl.key, f.key) && !p.hasKey(kindField, fl.key) {
+					if err := f.setMapValue(fv, fl.key[len(f.key):], fl); err != nil {
+						errs = append(errs, fmt.Errorf("field %q: %w", fl.key, err))
+					}
+				}
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// unixTime returns the time of Unix timestamp ts of given precision,
+// the precisions other than seconds, milliseconds and microseconds mean nanoseconds.
+func unixTime(ts int64, precision time.Duration) time.Time {
+	switch precision {
+	case time.Second:
+		return time.Unix(ts, 0)
+	case time.Millisecond:
+		return time.UnixMilli(ts)
+	case time.Microsecond:
+		return time.UnixMicro(ts)
+	}
+	return time.Unix(0, ts)
+}
+
+// setTimestamp stores the timestamp t to fv, the nil pointers are allocated.
+// It returns false if the type of fv is not supported.
+func (f *fieldPlan) setTimestamp(fv reflect.Value, t time.Time) bool {
+	fv = indirectAlloc(fv)
+	switch fv.Type() {
+	case timeType:
+		fv.Set(reflect.ValueOf(t))
+	case nullTimeType:
+		fv.Set(reflect.ValueOf(sql.NullTime{Time: t, Valid: true}))
+	default:
+		if !fv.CanInt() || fv.OverflowInt(unixValue(t, f.unit)) {
+			return false
+		}
+		fv.SetInt(unixValue(t, f.unit))
+	}
+	return true
+}
+
+// hasKey reports whether the plan has the tag or field with given key.
+func (p *typePlan) hasKey(kind metricKind, key string) bool {
+	for i := range p.fields {
+		if p.fields[i].kind == kind && p.fields[i].key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// isStringElem reports whether the values of map m may hold strings.
+func isStringElem(m reflect.Value) bool { return isStringType(m.Type().Elem()) }
+
+// isStringType reports whether the tag values are stored to the values of type t
+// as is: t is string, interface, ValueUnmarshaler, encoding.TextUnmarshaler
+// or pointer to any of them.
+func isStringType(t reflect.Type) bool {
+	t = indirectType(t)
+	return t.Kind() == reflect.String || t.Kind() == reflect.Interface ||
+		reflect.PointerTo(t).Implements(valueUnmarshalerType) ||
+		reflect.PointerTo(t).Implements(textUnmarshalerType)
+}
+
+// setMapValue parses the value of tag or field and stores it to map m by key,
+// the map is allocated if it is nil. The values of interface maps are stored as
+// string, int64, uint64, float64 or bool depending on the type of line protocol value.
+func (f *fieldPlan) setMapValue(m reflect.Value, key string, p pair) error {
+	if m.Kind() != reflect.Map {
+		return fmt.Errorf("%w: map into %s", ErrUnmarshalValue, m.Type())
+	}
+	if m.IsNil() {
+		m.Set(reflect.MakeMap(m.Type()))
+	}
+
+	ev := reflect.New(m.Type().Elem()).Elem()
+	if err := f.elem.setValue(ev, p); err != nil {
+		return err
+	}
+	m.SetMapIndex(reflect.ValueOf(key).Convert(m.Type().Key()), ev)
+	return nil
+}
+
+// parseFieldValue parses the value of field according to its line protocol type.
+func parseFieldValue(p pair) (any, error) {
+	if p.quoted {
+		return p.val, nil
+	}
+
+	s := p.val
+	switch {
+	case strings.HasSuffix(s, "i"):
+		if n, err := strconv.ParseInt(s[:len(s)-1], 10, 64); err == nil {
+			return n, nil
+		}
+	case strings.HasSuffix(s, "u"):
+		if n, err := strconv.ParseUint(s[:len(s)-1], 10, 64); err == nil {
+			return n, nil
+		}
+	}
+	switch s {
+	case "t", "T", "true", "True", "TRUE":
+		return true, nil
+	case "f", "F", "false", "False", "FALSE":
+		return false, nil
+	}
+	if n, err := strconv.ParseFloat(s, 64); err == nil {
+		return n, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnmarshalValue, s)
+}
+
+// parseValue parses the value of field to the typed Value, see parseFieldValue.
+func parseValue(p pair) (Value, error) {
+	val, err := parseFieldValue(p)
+	if err != nil {
+		return Value{}, err
+	}
+	switch val := val.(type) {
+	case int64:
+		return Int(val), nil
+	case uint64:
+		return Uint(val), nil
+	case float64:
+		return Float(val), nil
+	case bool:
+		return Bool(val), nil
+	}
+	return String(val.(string)), nil
+}
+
+// unmarshalValue stores the value of tag or field to fv with UnmarshalInfluxValue
+// or UnmarshalText (for strings) method of fv, ok is false if fv has none of them.
+func unmarshalValue(fv reflect.Value, p pair) (ok bool, err error) {
+	if !fv.CanAddr() {
+		return false, nil
+	}
+	switch u := fv.Addr().Interface().(type) {
+	case ValueUnmarshaler:
+		v, err := parseValue(p)
+		if err == nil {
+			err = u.UnmarshalInfluxValue(v)
+		}
+		if err != nil {
+			return true, fmt.Errorf("%w: %s into %s: %w", ErrUnmarshalValue, p.val, fv.Type(), err)
+		}
+		return true, nil
+	case encoding.TextUnmarshaler:
+		if !p.quoted {
+			return false, nil
+		}
+		if err := u.UnmarshalText([]byte(p.val)); err != nil {
+			return true, fmt.Errorf("%w: %s into %s: %w", ErrUnmarshalValue, p.val, fv.Type(), err)
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// fieldByIndexAlloc returns the nested field of struct v by index sequence,
+// the nil pointers to embedded structs are allocated. The ok is false if such
+// pointer can't be set, i.e. the embedded struct is unexported.
+func fieldByIndexAlloc(v reflect.Value, index []int) (_ reflect.Value, ok bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				if !v.CanSet() {
+					return v, false
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+// indirectAlloc dereferences the pointers of v, the nil ones are allocated.
+func indirectAlloc(v reflect.Value) reflect.Value {
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+// setValue parses the value of tag or field and stores it to fv, the time.Duration
+// values are read in the unit of plan f. The nil pointers are allocated.
+func (f *fieldPlan) setValue(fv reflect.Value, p pair) error {
+	fv = indirectAlloc(fv)
+	if ok, err := unmarshalValue(fv, p); ok {
+		return err
+	}
+	if f.coerce != InvalidValue && fv.Kind() != reflect.Interface {
+		var err error
+		if p, err = f.uncoerce(p, fv.Type()); err != nil {
+			return err
+		}
+	}
+	if f.unit == 0 || p.quoted || fv.Type() != durationType {
+		return setValue(fv, p)
+	}
+
+	if n, err := strconv.ParseInt(strings.TrimSuffix(p.val, "i"), 10, 64); err == nil {
+		fv.SetInt(n * int64(f.unit))
+		return nil
+	}
+	n, err := strconv.ParseFloat(p.val, 64)
+	if err != nil {
+		return fmt.Errorf("%w: %s into %s", ErrUnmarshalValue, p.val, fv.Type())
+	}
+	fv.SetInt(int64(math.Round(n * float64(f.unit))))
+	return nil
+}
+
+// uncoerce reverts the coercion of value set by tag option, e.g. the value 3i of
+// `influx:"count,field,int"` is converted back to float 3 of float64 field of type t.
+// The durations having unit are converted to float, the values of other than
+// numeric, boolean and string kinds are returned as is. The booleans are read
+// from 1 and 0 or from strings, the other numbers are not stored to them.
+func (f *fieldPlan) uncoerce(p pair, t reflect.Type) (pair, error) {
+	var k ValueKind
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		k = IntValue
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		k = UintValue
+	case reflect.Float32, reflect.Float64:
+		k = FloatValue
+	case reflect.Bool:
+		k = BoolValue
+	case reflect.String:
+		k = StringValue
+	default:
+		return p, nil
+	}
+	if t == durationType && f.unit != 0 {
+		k = FloatValue
+	}
+
+	v, err := parseValue(p)
+	if err != nil {
+		return p, err
+	}
+	if k == BoolValue {
+		// the booleans are coerced to 1 and 0 or to "true" and "false"
+		if v.kind == StringValue {
+			return pair{val: v.str}, nil
+		}
+		k = UintValue
+	}
+	if v, err = v.convert(k); err != nil {
+		return p, fmt.Errorf("%w: %w", ErrUnmarshalValue, err)
+	}
+
+	switch k {
+	case IntValue:
+		return pair{val: strconv.FormatInt(int64(v.num), 10)}, nil
+	case UintValue:
+		if t.Kind() == reflect.Bool && v.num <= 1 {
+			return pair{val: strconv.FormatBool(v.num == 1)}, nil
+		}
+		return pair{val: strconv.FormatUint(v.num, 10)}, nil
+	case FloatValue:
+		return pair{val: strconv.FormatFloat(math.Float64frombits(v.num), 'g', -1, 64)}, nil
+	}
+	return pair{val: v.str, quoted: true}, nil
+}
+
+// setValue parses the value of tag or field and stores it to fv, the interfaces
+// get the values of types returned by parseFieldValue.
+func setValue(fv reflect.Value, p pair) error {
+	if fv.Kind() == reflect.Interface {
+		val, err := parseFieldValue(p)
+		if err != nil {
+			return err
+		}
+		fv.Set(reflect.ValueOf(val))
+		return nil
+	}
+	if p.quoted {
+		if fv.Kind() != reflect.String {
+			return fmt.Errorf("%w: string into %s", ErrUnmarshalValue, fv.Type())
+		}
+		fv.SetString(p.val)
+		return nil
+	}
+
+	s := p.val
+	switch fv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(strings.TrimSuffix(s, "i"), 10, 64)
+		if err != nil || fv.OverflowInt(n) {
+			return fmt.Errorf("%w: %s into %s", ErrUnmarshalValue, s, fv.Type())
+		}
+		fv.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(strings.TrimSuffix(s, "u"), 10, 64)
+		if err != nil || fv.OverflowUint(n) {
+			return fmt.Errorf("%w: %s into %s", ErrUnmarshalValue, s, fv.Type())
+		}
+		fv.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, fv.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("%w: %s into %s", ErrUnmarshalValue, s, fv.Type())
+		}
+		fv.SetFloat(n)
+	case reflect.Bool:
+		switch s {
+		case "t", "T", "true", "True", "TRUE":
+			fv.SetBool(true)
+		case "f", "F", "false", "False", "FALSE":
+			fv.SetBool(false)
+		default:
+			return fmt.Errorf("%w: %s into %s", ErrUnmarshalValue, s, fv.Type())
+		}
+	default:
+		return fmt.Errorf("%w: %s into %s", ErrUnmarshalValue, s, fv.Type())
+	}
+	return nil
+}
+
+// A Decoder reads and decodes influxdb line protocol rows from an input stream.
+type Decoder struct {
+	r    *bufio.Reader
+	line int
+	// unread are the lines read ahead while looking for the end of string value
+	// of broken row, they are decoded as the next rows.
+	unread [][]byte
+}
+
+// maxRowLines is the maximum number of lines of row which string field values
+// hold newlines, the string which is not terminated within it is a syntax error.
+const maxRowLines = 1000
+
+// NewDecoder returns a new decoder that reads from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: bufio.NewReader(r)}
+}
+
+// readLine returns the next line of input including the newline.
+func (d *Decoder) readLine() ([]byte, error) {
+	if len(d.unread) > 0 {
+		b := d.unread[0]
+		d.unread = d.unread[1:]
+		d.line++
+		return b, nil
+	}
+	b, err := d.r.ReadBytes('\n')
+	if len(b) == 0 && err != nil {
+		return nil, err
+	}
+	d.line++
+	return b, nil
+}
+
+// Decode reads the next line protocol row from its input and stores it in the
+// struct pointed to by v, see Unmarshal for details. The empty lines and comments
+// (lines starting with #) are skipped, io.EOF is returned at the end of input.
+// The string field values may hold newlines up to maxRowLines lines of row,
+// the row which string is not terminated is a syntax error and the decoding
+// is resumed at its next line.
+func (d *Decoder) Decode(v any) error {
+	for {
+		b, err := d.readLine()
+		if err != nil {
+			return err
+		}
+
+		b = bytes.TrimLeft(b, " \t")
+		if len(bytes.TrimRight(b, "\r\n")) == 0 || b[0] == '#' {
+			continue
+		}
+		line := d.line
+		err = Unmarshal(b, v)
+		if errors.Is(err, errUnterminatedString) {
+			err = d.continueRow(b, v, err)
+		}
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		return nil
+	}
+}
+
+// continueRow appends the next lines to row b until its string field value is
+// terminated and stores the row to v. The row is parsed again only if the line
+// has a double quote, so the cost is linear in the number of lines. The read lines
+// are returned to the input and err is returned if the row is not parsed
+// within maxRowLines lines.
+func (d *Decoder) continueRow(b []byte, v any, err error) error {
+	var next [][]byte
+	for len(next) < maxRowLines-1 {
+		l, readErr := d.readLine()
+		if readErr != nil {
+			break
+		}
+		next = append(next, l)
+		b = append(b, l...)
+		if bytes.IndexByte(l, '"') < 0 {
+			continue
+		}
+		if rowErr := Unmarshal(b, v); !errors.Is(rowErr, ErrSyntax) {
+			return rowErr // the row is parsed, rowErr is nil or the error of value
+		}
+	}
+	d.line -= len(next)
+	d.unread = append(next, d.unread...)
+	return err
+}
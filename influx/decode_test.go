@@ -0,0 +1,461 @@
+package influx
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type TestDecode struct {
+	Name    string    `influx:",measurement"`
+	Host    string    `influx:"host name,tag"`
+	Region  string    `influx:"region,tag"`
+	Errors  int       `influx:"errors,field"`
+	Bytes   uint32    `influx:"bytes,field"`
+	Rate    float64   `influx:"rate,field"`
+	Ok      bool      `influx:"success,field"`
+	Message string    `influx:"message,field"`
+	Ts      time.Time `influx:",timestamp"`
+}
+
+func TestUnmarshal(t *testing.T) {
+	t.Parallel()
+
+	t.Run("roundtrip", func(t *testing.T) {
+		v := TestDecode{
+			Name:    "http requests",
+			Host:    "web,1",
+			Region:  "us=east 1",
+			Errors:  -12,
+			Bytes:   4096,
+			Rate:    0.25,
+			Ok:      true,
+			Message: `hotel "Queen" \ 	tab`,
+			Ts:      time.Date(2024, time.April, 10, 23, 23, 23, 0, time.UTC),
+		}
+
+		row, err := Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got TestDecode
+		if err := Unmarshal(row, &got); err != nil {
+			t.Fatal(err)
+		}
+		if !got.Ts.Equal(v.Ts) {
+			t.Errorf("expected timestamp %s, got: %s", v.Ts, got.Ts)
+		}
+		got.Ts = v.Ts
+		if got != v {
+			t.Errorf("expected: %+v, got: %+v", v, got)
+		}
+	})
+
+	t.Run("line", func(t *testing.T) {
+		var got TestDecode
+		err := Unmarshal([]byte(`cpu,region=eu,unknown=x errors=1i,bytes=2u,rate=3,success=F,message="a b",other=1`), &got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := TestDecode{Name: "cpu", Region: "eu", Errors: 1, Bytes: 2, Rate: 3, Message: "a b"}
+		if got != expected {
+			t.Errorf("expected: %+v, got: %+v", expected, got)
+		}
+	})
+
+	t.Run("tag/number", func(t *testing.T) {
+		var got struct {
+			Name string `influx:",measurement"`
+			Core int    `influx:"core,tag"`
+			Load int    `influx:"load,field"`
+		}
+		if err := Unmarshal([]byte("cpu,core=3i load=1i"), &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Core != 3 {
+			t.Errorf("expected 3, got: %d", got.Core)
+		}
+	})
+
+	t.Run("error/target", func(t *testing.T) {
+		var v TestDecode
+		for _, target := range []any{v, nil, (*TestDecode)(nil), new(int)} {
+			if err := Unmarshal([]byte("cpu errors=1i"), target); !errors.Is(err, ErrInvalidTarget) {
+				t.Errorf("expected ErrInvalidTarget for %T, got: %v", target, err)
+			}
+		}
+	})
+
+	t.Run("error/syntax", func(t *testing.T) {
+		for _, sample := range []string{
+			"",
+			"cpu",
+			"cpu,host errors=1i",
+			"cpu errors",
+			"cpu errors=",
+			`cpu message="unterminated`,
+			"cpu errors=1i 12a",
+			"cpu errors=1i 12 13",
+		} {
+			var v TestDecode
+			if err := Unmarshal([]byte(sample), &v); !errors.Is(err, ErrSyntax) {
+				t.Errorf("expected ErrSyntax for %q, got: %v", sample, err)
+			}
+		}
+	})
+
+	t.Run("error/value", func(t *testing.T) {
+		for _, sample := range []string{
+			`cpu errors="1"`,
+			"cpu errors=1.5",
+			"cpu bytes=-1i",
+			"cpu bytes=4294967296u",
+			"cpu success=yes",
+			"cpu message=1i",
+		} {
+			var v TestDecode
+			if err := Unmarshal([]byte(sample), &v); !errors.Is(err, ErrUnmarshalValue) {
+				t.Errorf("expected ErrUnmarshalValue for %q, got: %v", sample, err)
+			}
+		}
+	})
+}
+
+func TestDecoder(t *testing.T) {
+	t.Parallel()
+
+	t.Run("ok", func(t *testing.T) {
+		input := "# comment\n" +
+			"cpu,region=eu errors=1i 1712791403000000000\n" +
+			"\n" +
+			"  mem,region=us errors=2i 1712791404000000000\r\n" +
+			"disk errors=3i"
+
+		d := NewDecoder(strings.NewReader(input))
+		var got []TestDecode
+		for {
+			var v TestDecode
+			err := d.Decode(&v)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, v)
+		}
+
+		if len(got) != 3 {
+			t.Fatalf("expected 3 rows, got: %d", len(got))
+		}
+		if got[0].Name != "cpu" || got[1].Name != "mem" || got[2].Name != "disk" {
+			t.Errorf("unexpected measurements: %+v", got)
+		}
+		if got[1].Region != "us" || got[1].Errors != 2 || got[1].Ts.UnixNano() != 1712791404000000000 {
+			t.Errorf("unexpected row: %+v", got[1])
+		}
+		if !got[2].Ts.IsZero() {
+			t.Errorf("expected zero timestamp, got: %s", got[2].Ts)
+		}
+	})
+
+	t.Run("multiline", func(t *testing.T) {
+		input := "cpu message=\"line1\nline2\r\n\",errors=1i\n" +
+			"cpu message=\"unterminated\n"
+		d := NewDecoder(strings.NewReader(input))
+		var v TestDecode
+		if err := d.Decode(&v); err != nil {
+			t.Fatal(err)
+		}
+		if expected := "line1\nline2\r\n"; v.Message != expected || v.Errors != 1 {
+			t.Errorf("expected message: %q, got: %+v", expected, v)
+		}
+		err := d.Decode(&v)
+		if !errors.Is(err, ErrSyntax) || !strings.HasPrefix(err.Error(), "line 4: ") {
+			t.Errorf("expected syntax error of line 4, got: %v", err)
+		}
+	})
+
+	t.Run("unterminated", func(t *testing.T) {
+		input := "cpu message=\"broken\n" +
+			"cpu errors=1i\n" +
+			"cpu message=\"ok\",errors=2i\n" +
+			strings.Repeat("cpu errors=3i\n", 2*maxRowLines)
+		d := NewDecoder(strings.NewReader(input))
+		var v TestDecode
+		err := d.Decode(&v)
+		if !errors.Is(err, ErrSyntax) || !strings.HasPrefix(err.Error(), "line 1: ") {
+			t.Fatalf("expected syntax error of line 1, got: %v", err)
+		}
+
+		var n int
+		for {
+			v = TestDecode{}
+			err := d.Decode(&v)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			n++
+			if n == 2 && (v.Message != "ok" || v.Errors != 2) {
+				t.Errorf("unexpected row: %+v", v)
+			}
+		}
+		if expected := 2 + 2*maxRowLines; n != expected {
+			t.Errorf("expected %d rows, got: %d", expected, n)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		d := NewDecoder(strings.NewReader("cpu errors=1i\ncpu errors\n"))
+		var v TestDecode
+		if err := d.Decode(&v); err != nil {
+			t.Fatal(err)
+		}
+		err := d.Decode(&v)
+		if !errors.Is(err, ErrSyntax) {
+			t.Errorf("expected ErrSyntax, got: %v", err)
+		}
+		if err == nil || !strings.HasPrefix(err.Error(), "line 2: ") {
+			t.Errorf("expected line number in error, got: %v", err)
+		}
+	})
+}
+
+func TestUnmarshalMaps(t *testing.T) {
+	t.Parallel()
+
+	row := `requests,host=web-1,method=GET,user\ agent=curl ` +
+		`load=3i,bytes=512u,cached=T,error="oops",time=0.25,net_cnt_rx=10u,net_cnt_tx=20u 1712791403000000000`
+
+	var got TestMaps
+	if err := Unmarshal([]byte(row), &got); err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Host != "web-1" || got.Load != 3 {
+		t.Errorf("unexpected struct fields: %+v", got)
+	}
+	if len(got.Labels) != 2 || got.Labels["method"] != "GET" || got.Labels["user agent"] != "curl" {
+		t.Errorf("unexpected tags: %v", got.Labels)
+	}
+	expected := map[string]any{
+		"bytes": uint64(512), "cached": true, "error": "oops", "time": 0.25,
+		"net_cnt_rx": uint64(10), "net_cnt_tx": uint64(20),
+	}
+	if len(got.Values) != len(expected) {
+		t.Errorf("expected: %v, got: %v", expected, got.Values)
+	}
+	for k, v := range expected {
+		if got.Values[k] != v {
+			t.Errorf("expected %s=%v (%T), got: %v (%T)", k, v, v, got.Values[k], got.Values[k])
+		}
+	}
+	if got.Net.Counters["rx"] != 10 || got.Net.Counters["tx"] != 20 {
+		t.Errorf("unexpected counters: %v", got.Net.Counters)
+	}
+}
+
+func TestCanonicalize(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		Sample   string
+		Expected string
+	}{
+		{
+			Sample:   "cpu,zone=b,host=web\\ 1,az=1 user=1i,idle=2.5,ok=T 1712791403000000000",
+			Expected: "cpu,az=1,host=web\\ 1,zone=b idle=2.5,ok=true,user=1i 1712791403000000000",
+		},
+		{
+			Sample:   "cpu,az=1,host=web\\ 1,zone=b idle=2.5,ok=true,user=1i 1712791403000000000\n",
+			Expected: "cpu,az=1,host=web\\ 1,zone=b idle=2.5,ok=true,user=1i 1712791403000000000",
+		},
+		{
+			Sample:   `my\ cpu msg="a \"b\"",flag=FALSE`,
+			Expected: `my\ cpu flag=false,msg="a \"b\""`,
+		},
+		{
+			Sample:   "cpu,b=2,a=1,a=0 v=1",
+			Expected: "cpu,a=1,a=0,b=2 v=1",
+		},
+	}
+
+	for _, testCase := range testCases {
+		row, err := Canonicalize([]byte(testCase.Sample))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if testCase.Expected != string(row) {
+			t.Errorf("expected: %s, got: %s", testCase.Expected, row)
+		}
+	}
+
+	t.Run("marshal", func(t *testing.T) {
+		ts := time.Now()
+		row := ConvertToInfluxLineProtocol(TestMaps{
+			Name: "requests", Host: "web-1", Labels: map[string]string{"app": "api"}, Load: 1,
+			Values: map[string]any{"bytes": 12, "msg": `a "b"`}, Ts: ts,
+		})
+		canonical, err := Canonicalize([]byte(row))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := `requests,app=api,host=web-1 bytes=12i,load=1i,msg="a \"b\"" ` +
+			strconv.FormatInt(ts.UnixNano(), 10)
+		if expected != string(canonical) {
+			t.Errorf("expected: %s, got: %s", expected, canonical)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		if _, err := Canonicalize([]byte("cpu")); !errors.Is(err, ErrSyntax) {
+			t.Errorf("expected ErrSyntax, got: %v", err)
+		}
+	})
+}
+
+func FuzzUnmarshal(f *testing.F) {
+	f.Add("cpu", "web-1", "eu", `hotel "Queen"`)
+	f.Add("http requests", "web,1", "us=east 1", "a\\b\nc")
+	f.Add(`a\`, `b\`, "\n", `\"`)
+	f.Fuzz(func(t *testing.T, name, host, region, message string) {
+		v := TestDecode{
+			Name: name, Host: host, Region: region, Errors: 1, Message: message,
+			Ts: time.Unix(0, 1712791403123456789),
+		}
+		row, err := Marshal(v)
+		if err != nil {
+			return // the values are not allowed by line protocol, e.g. empty tag values
+		}
+
+		var got TestDecode
+		if err := Unmarshal(row, &got); err != nil {
+			t.Fatalf("%s: %v", row, err)
+		}
+		got.Ts = v.Ts
+		if got != v {
+			t.Errorf("%s: expected: %+v, got: %+v", row, v, got)
+		}
+
+		d := NewDecoder(strings.NewReader(string(row) + "\n"))
+		got = TestDecode{}
+		if err := d.Decode(&got); err != nil {
+			t.Fatalf("%s: %v", row, err)
+		}
+		got.Ts = v.Ts
+		if got != v {
+			t.Errorf("%s: expected: %+v, got: %+v", row, v, got)
+		}
+	})
+}
+
+type commonTags struct {
+	Region string `influx:"region,tag"`
+}
+
+type TestEmbeddedUnexported struct {
+	*commonTags
+	Name   string `influx:",measurement"`
+	Errors int    `influx:"errors,field"`
+}
+
+func TestUnmarshalEmbedded(t *testing.T) {
+	t.Parallel()
+
+	var got TestEmbeddedUnexported
+	if err := Unmarshal([]byte("cpu,region=eu errors=1i"), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "cpu" || got.Errors != 1 || got.commonTags != nil {
+		t.Errorf("unexpected value: %+v", got)
+	}
+
+	got = TestEmbeddedUnexported{commonTags: &commonTags{}}
+	if err := Unmarshal([]byte("cpu,region=eu errors=1i"), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Region != "eu" {
+		t.Errorf("expected region: eu, got: %+v", got)
+	}
+}
+
+func TestUnmarshalPointers(t *testing.T) {
+	t.Parallel()
+
+	ts := time.Unix(1712791403, 0)
+	name, host, errs, rate, weight := "node", "web-1", 12, 0.5, int64(5000)
+	ratePtr := &rate
+	v := TestPointers{
+		Name: &name, Host: &host, Region: "eu", Errors: &errs, Rate: &ratePtr,
+		Sensor: int64(45), Weight: &weight, Timestamp: &ts,
+	}
+	row, err := Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got TestPointers
+	if err := Unmarshal(row, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Name == nil || *got.Name != name || got.Host == nil || *got.Host != host ||
+		got.Region != "eu" || got.Errors == nil || *got.Errors != errs ||
+		got.Rate == nil || *got.Rate == nil || **got.Rate != rate || got.Sensor != int64(45) ||
+		got.Weight == nil || *got.Weight != weight || got.Timestamp == nil || !got.Timestamp.Equal(ts) {
+		t.Errorf("unexpected value: %+v", got)
+	}
+
+	got = TestPointers{}
+	if err := Unmarshal([]byte("node errors=1i"), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Host != nil || got.Weight != nil || got.Timestamp != nil {
+		t.Errorf("expected nil pointers of missing values, got: %+v", got)
+	}
+}
+
+type TestDecodeMethods struct {
+	Name    string        `influx:",measurement"`
+	Host    net.IP        `influx:"host,tag"`
+	Addr    net.IP        `influx:"addr,field"`
+	Elapsed Duration      `influx:"elapsed,field"`
+	Sensor  SpecialString `influx:"sensor,field"`
+	Errors  int           `influx:"errors,field"`
+	Ts      time.Time     `influx:",timestamp"`
+}
+
+func TestUnmarshalMethods(t *testing.T) {
+	t.Parallel()
+
+	v := TestDecodeMethods{
+		Name: "node", Host: net.IPv4(10, 0, 0, 1), Addr: net.ParseIP("::1"),
+		Elapsed: Duration{Value: "1.5s", To: time.Second}, Sensor: "onboard,45.16", Errors: 2,
+		Ts: time.Unix(1712791403, 0),
+	}
+	row, err := Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := `node,host=10.0.0.1 addr="::1",elapsed=1.50,sensor=45.16,errors=2i 1712791403000000000`; expected != string(row) {
+		t.Errorf("expected: %s, got: %s", expected, row)
+	}
+
+	got := TestDecodeMethods{Elapsed: Duration{To: time.Second}}
+	err = Unmarshal(row, &got)
+	if expected := `field "sensor": cannot unmarshal value: 45.16 into influx.SpecialString`; !errors.Is(err, ErrUnmarshalValue) ||
+		expected != err.Error() {
+		t.Errorf("expected: %s, got: %v", expected, err)
+	}
+	if !got.Host.Equal(v.Host) || !got.Addr.Equal(v.Addr) || got.Elapsed != v.Elapsed ||
+		got.Sensor != "" || got.Errors != 2 || !got.Ts.Equal(v.Ts) {
+		t.Errorf("expected the rest of row stored, got: %+v", got)
+	}
+}
@@ -0,0 +1,1221 @@
+package influx
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metricKind is the kind of line protocol element described by struct tag.
+type metricKind int
+
+const (
+	kindTag metricKind = iota + 1
+	kindField
+	kindMeasurement
+	kindTimestamp
+	kindTags   // map of tags
+	kindFields // map of fields
+)
+
+func (k metricKind) String() string {
+	switch k {
+	case kindTag:
+		return "tag"
+	case kindField:
+		return "field"
+	case kindMeasurement:
+		return "measurement"
+	case kindTimestamp:
+		return "timestamp"
+	case kindTags:
+		return "tags"
+	case kindFields:
+		return "fields"
+	}
+	return "unknown"
+}
+
+func parseMetricKind(s string) metricKind {
+	switch s {
+	case "tag":
+		return kindTag
+	case "field":
+		return kindField
+	case "measurement":
+		return kindMeasurement
+	case "timestamp":
+		return kindTimestamp
+	case "tags":
+		return kindTags
+	case "fields":
+		return kindFields
+	}
+	return 0
+}
+
+// Marshaler is implemented by the types which encode themselves to the value
+// of tag or field, the returned string is written to the row as is.
+type Marshaler interface {
+	MarshalInflux() (string, error)
+}
+
+// ValueMarshaler is implemented by the types which encode themselves to the typed
+// value of tag or field, it is formatted and escaped by encoder. It takes precedence
+// over Marshaler if the type implements both.
+type ValueMarshaler interface {
+	MarshalInfluxValue() (Value, error)
+}
+
+// Measurementer is implemented by the structs which know their measurement,
+// the measurement field, if any, takes precedence.
+type Measurementer interface {
+	InfluxMeasurement() string
+}
+
+// Timestamper is implemented by the types knowing their timestamp: the struct
+// which is encoded or the value of its field tagged as timestamp. The timestamp
+// field, if any, takes precedence over the method of struct.
+type Timestamper interface {
+	InfluxTimestamp() time.Time
+}
+
+// LineMarshaler is implemented by the structs which encode themselves to the line
+// protocol row, the struct tags and other methods are ignored in this case.
+// The empty row fails with ErrNoFields and the row having newlines outside of
+// string field values (i.e. several rows) with ErrNewline.
+type LineMarshaler interface {
+	MarshalInfluxLine() ([]byte, error)
+}
+
+// Tagger is implemented by the structs having the computed tags, which are added
+// to the tagged ones.
+type Tagger interface {
+	InfluxTags() map[string]string
+}
+
+// Fielder is implemented by the structs having the computed fields, which are added
+// to the tagged ones. The values are encoded the same way as the values of fields.
+type Fielder interface {
+	InfluxFields() map[string]any
+}
+
+// method describes how the method of interface is called on the values of type.
+type method uint8
+
+const (
+	noMethod      method = iota // the type does not implement interface
+	valueMethod                 // the method has value receiver
+	pointerMethod               // the method has pointer receiver
+)
+
+// methodOf reports whether type t or the pointer to t implements the interface
+// iface of one method. The error is returned if t has the method of the same name
+// but with other signature, which is likely a mistake.
+func methodOf(t, iface reflect.Type) (method, error) {
+	if t.Implements(iface) {
+		return valueMethod, nil
+	}
+	if t.Kind() != reflect.Interface && reflect.PointerTo(t).Implements(iface) {
+		return pointerMethod, nil
+	}
+
+	want := iface.Method(0)
+	m, ok := reflect.PointerTo(t).MethodByName(want.Name)
+	if !ok {
+		return noMethod, nil
+	}
+	in := make([]reflect.Type, m.Type.NumIn()-1) // without receiver
+	for i := range in {
+		in[i] = m.Type.In(i + 1)
+	}
+	out := make([]reflect.Type, m.Type.NumOut())
+	for i := range out {
+		out[i] = m.Type.Out(i)
+	}
+	got := reflect.FuncOf(in, out, m.Type.IsVariadic())
+	return noMethod, fmt.Errorf("%w: %s.%s is %s, %s expected",
+		ErrMethodSignature, t, want.Name, got, want.Type)
+}
+
+// recv returns the receiver of method m for value v. The pointer to the copy
+// of v is returned for pointer receivers if v is not addressable.
+func (m method) recv(v reflect.Value) reflect.Value {
+	if m != pointerMethod {
+		return v
+	}
+	if v.CanAddr() {
+		return v.Addr()
+	}
+	p := reflect.New(v.Type())
+	p.Elem().Set(v)
+	return p
+}
+
+var (
+	marshalerType      = reflect.TypeFor[Marshaler]()
+	valueMarshalerType = reflect.TypeFor[ValueMarshaler]()
+	measurementerType  = reflect.TypeFor[Measurementer]()
+	timestamperType    = reflect.TypeFor[Timestamper]()
+	lineMarshalerType  = reflect.TypeFor[LineMarshaler]()
+	taggerType         = reflect.TypeFor[Tagger]()
+	fielderType        = reflect.TypeFor[Fielder]()
+	timeType           = reflect.TypeFor[time.Time]()
+	durationType       = reflect.TypeFor[time.Duration]()
+	nullTimeType       = reflect.TypeFor[sql.NullTime]()
+	stringerType       = reflect.TypeFor[fmt.Stringer]()
+	formatterType      = reflect.TypeFor[fmt.Formatter]()
+	errorType          = reflect.TypeFor[error]()
+)
+
+// fieldPlan describes how to encode the tagged struct field.
+type fieldPlan struct {
+	index []int  // index sequence of field for reflect.Value.FieldByIndex
+	key   string // name of tag or field as is
+	name  string // escaped name of tag or field
+	kind  metricKind
+	field string // name of struct field, nested fields are separated by dots
+
+	// omitEmpty is set by omitempty option, the zero values are omitted.
+	omitEmpty bool
+	// precision is the precision of timestamp set by tag option.
+	precision time.Duration
+	// unit is the unit of integer timestamp or time.Duration value set by unit
+	// option, e.g. unit=ms, digits is the number of digits after the decimal
+	// point of duration set by precision option, -1 if not set.
+	unit   time.Duration
+	digits int
+	// timestamper is set if the type of timestamp implements Timestamper.
+	timestamper method
+	// coerce is the type of value set by tag option, the values are
+	// converted to it.
+	coerce ValueKind
+	// dynamic is set for interface fields, the type of their values
+	// is known only at encoding.
+	dynamic bool
+	valueInfo
+
+	// elem is the plan of values of map of tags or fields, the key
+	// of map is prefixed with the key of field.
+	elem *fieldPlan
+}
+
+// valueInfo describes how to format the values of type.
+type valueInfo struct {
+	// marshaler is set if the type implements Marshaler, valueMarshaler
+	// is set if it implements ValueMarshaler.
+	marshaler      method
+	valueMarshaler method
+	// useFmt is set for types having their own text representation
+	// (fmt.Stringer etc), the values of other than numeric, boolean and
+	// string kinds are formatted by fmt package and written as strings.
+	useFmt bool
+}
+
+func newValueInfo(t reflect.Type) (info valueInfo, err error) {
+	if info.marshaler, err = methodOf(t, marshalerType); err != nil {
+		return info, err
+	}
+	if info.valueMarshaler, err = methodOf(t, valueMarshalerType); err != nil {
+		return info, err
+	}
+	info.useFmt = t.Implements(stringerType) ||
+		t.Implements(formatterType) || t.Implements(errorType)
+	return info, nil
+}
+
+// typeAssert returns the value of v, which is of type T. Unlike v.Interface
+// it does not allocate for the addressable values.
+func typeAssert[T any](v reflect.Value) T {
+	if v.CanAddr() {
+		return *v.Addr().Interface().(*T)
+	}
+	return v.Interface().(T)
+}
+
+// indirectType returns the type t points to, the pointers are dereferenced.
+func indirectType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t
+}
+
+// indirect dereferences the pointers and interfaces of v, ok is false
+// if any of them is nil.
+func indirect(v reflect.Value) (_ reflect.Value, ok bool) {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return v, false
+		}
+		v = v.Elem()
+	}
+	return v, true
+}
+
+// typePlan is the encoding plan of struct type, it is compiled once per type
+// to avoid parsing of struct tags and lookup of methods on every call.
+type typePlan struct {
+	typ           reflect.Type
+	measurementer method
+	timestamper   method
+	lineMarshaler method
+	tagger        method
+	fielder       method
+	// hookTags and hookFields are the plans of maps returned by InfluxTags
+	// and InfluxFields methods.
+	hookTags, hookFields fieldPlan
+	// optionalTimestamp is set by omitempty option of timestamp field,
+	// the row is written without timestamp if it is zero.
+	optionalTimestamp bool
+	// fields are the tagged fields of struct and of its embedded and
+	// prefixed nested structs.
+	fields []fieldPlan
+	// err is the error of plan compilation, it is returned on every use.
+	err error
+	// strictErr holds the mistakes of struct tags reported in strict mode,
+	// see Validate.
+	strictErr error
+	issues    []error
+}
+
+var planCache sync.Map // map[reflect.Type]*typePlan
+
+// cachedTypePlan returns the encoding plan of t, compiling it on the first use.
+func cachedTypePlan(t reflect.Type) (*typePlan, error) {
+	if p, ok := planCache.Load(t); ok {
+		return p.(*typePlan), p.(*typePlan).err
+	}
+	p, _ := planCache.LoadOrStore(t, newTypePlan(t))
+	return p.(*typePlan), p.(*typePlan).err
+}
+
+func newTypePlan(t reflect.Type) *typePlan {
+	p := &typePlan{typ: t}
+	if p.measurementer, p.err = methodOf(t, measurementerType); p.err != nil {
+		return p
+	}
+	if p.timestamper, p.err = methodOf(t, timestamperType); p.err != nil {
+		return p
+	}
+	if p.lineMarshaler, p.err = methodOf(t, lineMarshalerType); p.err != nil {
+		return p
+	}
+	if p.tagger, p.err = methodOf(t, taggerType); p.err != nil {
+		return p
+	}
+	if p.fielder, p.err = methodOf(t, fielderType); p.err != nil {
+		return p
+	}
+	p.hookTags = fieldPlan{
+		kind: kindTags, field: "InfluxTags()",
+		elem: &fieldPlan{kind: kindTag, field: "InfluxTags()"},
+	}
+	p.hookFields = fieldPlan{
+		kind: kindFields, field: "InfluxFields()",
+		elem: &fieldPlan{kind: kindField, field: "InfluxFields()", dynamic: true},
+	}
+	p.err = p.addFields(t, nil, "", "", map[reflect.Type]bool{t: true})
+	p.checkKeys()
+	p.strictErr, p.issues = errors.Join(p.issues...), nil
+	return p
+}
+
+// issue records the mistake of struct tag of field reported in strict mode.
+func (p *typePlan) issue(field string, err error) {
+	p.issues = append(p.issues, p.fieldError(field, err))
+}
+
+// fieldError returns FieldError of struct field, the nested fields are named
+// by their path in the struct of plan.
+func (p *typePlan) fieldError(field string, err error) error {
+	return &FieldError{Struct: p.typ.String(), Field: field, Err: err}
+}
+
+// checkKeys records the duplicate keys of tags and fields and the duplicate
+// measurement and timestamp fields.
+func (p *typePlan) checkKeys() {
+	type key struct {
+		kind metricKind
+		name string
+	}
+	seen := make(map[key]string) // struct field by key
+	for _, f := range p.fields {
+		k := key{kind: f.kind}
+		switch f.kind {
+		case kindTag, kindField:
+			k.name = f.key
+		case kindMeasurement, kindTimestamp:
+		default:
+			continue
+		}
+		other, ok := seen[k]
+		if !ok {
+			seen[k] = f.field
+			continue
+		}
+		if k.name == "" {
+			p.issue(f.field, fmt.Errorf("%w %s, see %s", ErrDuplicate, f.kind, other))
+		} else {
+			p.issue(f.field, fmt.Errorf("%w %s %q, see %s", ErrDuplicate, f.kind, k.name, other))
+		}
+	}
+}
+
+// checkOptions records the options of struct tag which are unknown or do not apply
+// to the kind of data, e.g. the timestamp precision of field. The unit and precision
+// of values other than time.Duration are checked by setDurationOpts.
+func (p *typePlan) checkOptions(field string, kind metricKind, st structTag) {
+	for _, opt := range st.opts {
+		var ok bool
+		switch key, _, _ := strings.Cut(opt, "="); key {
+		case "omitempty", "unit":
+			ok = kind != kindMeasurement
+		case "s", "ms", "us", "ns":
+			ok = kind == kindTimestamp
+		case "int", "uint", "float", "string", "precision":
+			ok = kind != kindMeasurement && kind != kindTimestamp
+		}
+		if !ok {
+			p.issue(field, fmt.Errorf("%w %q of %s", ErrUnknownOption, opt, kind))
+		}
+	}
+}
+
+// addFields adds the tagged fields of struct type t to plan. The anonymous
+// struct fields without tags are flattened as well as the struct fields
+// tagged as `influx:"net_,prefix"`, the prefix is prepended to the names of
+// their tags and fields. The index and parent are the index sequence and the name
+// of t in the top-level struct, the path holds the struct types being flattened
+// to detect cycles.
+func (p *typePlan) addFields(
+	t reflect.Type, index []int, parent, prefix string, path map[reflect.Type]bool,
+) error {
+	for i := range t.NumField() {
+		sf := t.Field(i)
+		tag, tagged := sf.Tag.Lookup("influx")
+		st := parseTag(tag)
+		k, v := st.name, st.kind
+		fieldIndex := append(index[:len(index):len(index)], i)
+		field := parent + sf.Name
+
+		if tagged && !sf.IsExported() && (!sf.Anonymous || v != "prefix") {
+			// the values of unexported fields are not accessible by reflection,
+			// these are skipped like in encoding/json, only the exported fields
+			// of unexported embedded structs are added
+			p.issue(field, ErrUnexportedField)
+			continue
+		}
+
+		if v == "prefix" || !tagged && sf.Anonymous {
+			ft := indirectType(sf.Type)
+			if ft.Kind() != reflect.Struct {
+				if tagged {
+					return p.fieldError(field, fmt.Errorf("%w: prefix of non-struct %s", ErrUnsupportedType, sf.Type))
+				}
+				continue
+			}
+			if path[ft] {
+				return p.fieldError(field, fmt.Errorf("%w: cyclic nesting of %s", ErrUnsupportedType, ft))
+			}
+			path[ft] = true
+			err := p.addFields(ft, fieldIndex, field+".", prefix+k, path)
+			delete(path, ft)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		if !tagged {
+			continue
+		}
+		kind := parseMetricKind(v)
+		if kind == 0 {
+			p.issue(field, fmt.Errorf("%w %q", ErrUnknownKind, v))
+			continue
+		}
+		p.checkOptions(field, kind, st)
+		if kind != kindMeasurement && kind != kindTimestamp {
+			k = prefix + k
+		}
+		if (kind == kindTag || kind == kindTags) && st.coercion() != InvalidValue &&
+			st.coercion() != StringValue {
+			// the tag values are strings, the type of value is meaningless
+			return p.fieldError(field, fmt.Errorf("%w: %s option of %s", ErrUnsupportedType, st.coercion(), kind))
+		}
+		fp := fieldPlan{
+			index: fieldIndex, key: k, name: escapeTagKVFieldK(k), kind: kind,
+			field: field, omitEmpty: st.hasOption("omitempty"),
+			precision: st.precision(), coerce: st.coercion(),
+		}
+		switch kind {
+		case kindMeasurement:
+			ft := indirectType(sf.Type)
+			if info, _ := newValueInfo(ft); !info.useFmt &&
+				ft.Kind() != reflect.Interface && !isValueKind(ft.Kind()) {
+				return p.fieldError(field, fmt.Errorf("%w: measurement of %s", ErrUnsupportedType, sf.Type))
+			}
+		case kindTimestamp:
+			p.optionalTimestamp = fp.omitEmpty
+			ft := indirectType(sf.Type)
+			fp.dynamic = ft.Kind() == reflect.Interface
+			var err error
+			if fp.timestamper, err = methodOf(ft, timestamperType); err != nil {
+				return p.fieldError(field, err)
+			}
+			if fp.timestamper == noMethod && !isTimestampType(ft) {
+				return p.fieldError(field, fmt.Errorf("%w: timestamp of %s", ErrUnsupportedType, sf.Type))
+			}
+			if unit, ok := st.option("unit"); ok {
+				if fp.unit = precisions[unit]; fp.unit == 0 {
+					return p.fieldError(field, fmt.Errorf("%w: timestamp unit %q", ErrUnsupportedType, unit))
+				}
+			}
+		case kindTag, kindField:
+			if err := fp.setValueType(sf.Type); err != nil {
+				return p.fieldError(field, err)
+			}
+			if err := fp.setDurationOpts(sf.Type, st); err != nil {
+				return p.fieldError(field, err)
+			}
+		case kindTags, kindFields:
+			ft := indirectType(sf.Type)
+			if ft.Kind() != reflect.Map || ft.Key().Kind() != reflect.String {
+				return p.fieldError(field, fmt.Errorf(
+					"%w: %s of %s, map with string keys expected", ErrUnsupportedType, kind, sf.Type))
+			}
+			fp.elem = &fieldPlan{
+				kind: kindTag, field: fp.field, omitEmpty: fp.omitEmpty, coerce: fp.coerce,
+			}
+			if kind == kindFields {
+				fp.elem.kind = kindField
+			}
+			if err := fp.elem.setValueType(ft.Elem()); err != nil {
+				return p.fieldError(field, err)
+			}
+			if err := fp.elem.setDurationOpts(ft.Elem(), st); err != nil {
+				return p.fieldError(field, err)
+			}
+		}
+		p.fields = append(p.fields, fp)
+	}
+	return nil
+}
+
+// isTimestampType reports whether the values of type t may be used as timestamp:
+// time.Time, sql.NullTime, integer Unix time or interface which value is checked
+// at encoding. The types implementing Timestamper are checked separately.
+func isTimestampType(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Interface:
+		return true
+	}
+	return t == timeType || t == nullTimeType
+}
+
+// timestampOf returns the time of timestamp field value fv, the invalid sql.NullTime
+// is the zero time.
+func (f *fieldPlan) timestampOf(fv reflect.Value) (time.Time, error) {
+	m := f.timestamper
+	if f.dynamic {
+		var err error
+		if m, err = methodOf(fv.Type(), timestamperType); err != nil {
+			return time.Time{}, err
+		}
+	}
+	if m != noMethod {
+		return m.recv(fv).Interface().(Timestamper).InfluxTimestamp(), nil
+	}
+	switch fv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return unixTime(fv.Int(), f.unit), nil
+	}
+	switch fv.Type() {
+	case timeType:
+		return typeAssert[time.Time](fv), nil
+	case nullTimeType:
+		if t := typeAssert[sql.NullTime](fv); t.Valid {
+			return t.Time, nil
+		}
+		return time.Time{}, nil
+	}
+	return time.Time{}, fmt.Errorf("%w: timestamp of %s", ErrUnsupportedType, fv.Type())
+}
+
+// setValueType sets the formatting info of values of type t.
+// It fails if the values of t may not be encoded.
+func (f *fieldPlan) setValueType(t reflect.Type) (err error) {
+	t = indirectType(t)
+	f.dynamic = t.Kind() == reflect.Interface
+	if f.dynamic {
+		return nil
+	}
+	if f.valueInfo, err = newValueInfo(t); err != nil {
+		return err
+	}
+	if !f.valueInfo.encodes(t) {
+		return fmt.Errorf("%w: %s of %s", ErrUnsupportedType, f.kind, t)
+	}
+	return nil
+}
+
+// encodes reports whether the values of type t having info may be encoded
+// as tag or field: these are numbers, booleans and strings, time.Duration and
+// the types implementing Marshaler, ValueMarshaler or formatted by fmt package.
+func (info valueInfo) encodes(t reflect.Type) bool {
+	return info.marshaler != noMethod || info.valueMarshaler != noMethod || info.useFmt ||
+		isValueKind(t.Kind())
+}
+
+// isValueKind reports whether the values of kind k are encoded as is.
+func isValueKind(k reflect.Kind) bool {
+	switch k {
+	case reflect.String, reflect.Bool, reflect.Float32, reflect.Float64,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+// setDurationOpts sets the unit and precision of time.Duration values of type t
+// by the options of tag, e.g. `influx:"time,field,unit=min,precision=2"`.
+// The options are not allowed for other types.
+func (f *fieldPlan) setDurationOpts(t reflect.Type, st structTag) error {
+	unit, hasUnit := st.option("unit")
+	prec, hasPrec := st.option("precision")
+	if indirectType(t) != durationType {
+		if hasUnit || hasPrec {
+			return fmt.Errorf("%w: unit and precision of %s, time.Duration expected", ErrUnsupportedType, t)
+		}
+		return nil
+	}
+
+	f.unit, f.digits = time.Nanosecond, -1
+	if hasUnit {
+		if f.unit = durationUnits[unit]; f.unit == 0 {
+			return fmt.Errorf("%w: duration unit %q", ErrUnsupportedType, unit)
+		}
+	}
+	if hasPrec {
+		n, err := strconv.Atoi(prec)
+		if err != nil || n < 0 {
+			return fmt.Errorf("%w: duration precision %q", ErrUnsupportedType, prec)
+		}
+		f.digits = n
+	}
+	return nil
+}
+
+// durationValue returns the duration d in units of plan: integer nanoseconds,
+// microseconds and milliseconds or float seconds, minutes and hours, the float
+// with fixed number of digits if the precision is set.
+func (f *fieldPlan) durationValue(d time.Duration) Value {
+	if f.unit == 0 {
+		return Int(int64(d)) // the dynamic value
+	}
+	if f.digits < 0 && f.unit < time.Second {
+		return Int(int64(d / f.unit))
+	}
+	v := float64(d/f.unit) + float64(d%f.unit)/float64(f.unit)
+	if f.digits < 0 {
+		return Float(v)
+	}
+	return FixedFloat(v, f.digits)
+}
+
+// fieldByIndex returns the nested field of struct v by index sequence,
+// ok is false if the field is reached through nil pointer.
+func fieldByIndex(v reflect.Value, index []int) (_ reflect.Value, ok bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				return v, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+// encodeState holds the parts of line protocol row while encoding a point.
+type encodeState struct {
+	tags     []byte // ",k=v,k=v"
+	tagSpans []tagSpan
+	fields   []byte // "k=v,k=v"
+	keys     []string
+	opts     encOpts
+	plan     *typePlan
+}
+
+// tagSpan is the position of tag in encodeState.tags, used for sorting of tags.
+type tagSpan struct {
+	key        string
+	start, end int
+}
+
+var encodeStatePool = sync.Pool{New: func() any { return new(encodeState) }}
+
+func newEncodeState(p *typePlan, opts encOpts) *encodeState {
+	e := encodeStatePool.Get().(*encodeState)
+	e.tags = e.tags[:0]
+	e.tagSpans = e.tagSpans[:0]
+	e.fields = e.fields[:0]
+	e.opts = opts
+	e.plan = p
+	return e
+}
+
+func (e *encodeState) release() {
+	e.plan = nil
+	encodeStatePool.Put(e)
+}
+
+// fieldError returns FieldError of field f.
+func (e *encodeState) fieldError(f *fieldPlan, err error) error {
+	return &FieldError{Struct: e.plan.typ.String(), Field: f.field, Err: err}
+}
+
+// planOf returns the encoding plan of struct type t, the mistakes of struct tags
+// are errors in strict mode.
+func planOf(t reflect.Type, opts encOpts) (*typePlan, error) {
+	p, err := cachedTypePlan(t)
+	if err == nil && opts.strict {
+		err = p.strictErr
+	}
+	return p, err
+}
+
+// appendLines appends the line protocol rows of v to dst, v is a struct
+// or a slice, array or channel of structs, or pointer to any of them.
+// The n is the number of appended rows.
+func appendLines(dst []byte, v reflect.Value, opts encOpts) (_ []byte, n int, err error) {
+	rv, ok := indirect(v)
+	if !ok {
+		return dst, 0, fmt.Errorf("%w: nil %s", ErrUnsupportedType, v.Type())
+	}
+	v = rv
+
+	switch v.Kind() {
+	case reflect.Invalid:
+		return dst, 0, fmt.Errorf("%w: nil", ErrUnsupportedType)
+	case reflect.Struct:
+		start := len(dst)
+		p, err := planOf(v.Type(), opts)
+		if err == nil {
+			dst, err = p.appendLine(dst, v, opts)
+		}
+		if err != nil {
+			return dst[:start], 0, opts.handle(ErrorSkipPoint, err)
+		}
+		return dst, 1, nil
+	case reflect.Slice, reflect.Array:
+		if !isStructElem(v.Type().Elem()) {
+			break
+		}
+		start := len(dst)
+		for i := range v.Len() {
+			mark := len(dst)
+			var err error
+			if dst, err = appendSliceElem(dst, v.Index(i), start, opts); err != nil {
+				if err = opts.handle(ErrorSkipPoint, fmt.Errorf("element %d: %w", i, err)); err != nil {
+					return dst[:start], 0, err
+				}
+				dst = dst[:mark]
+				continue
+			}
+			n++
+		}
+		return dst, n, nil
+	case reflect.Chan:
+		if v.Type().ChanDir()&reflect.RecvDir == 0 || !isStructElem(v.Type().Elem()) {
+			break
+		}
+		start := len(dst)
+		for i := 0; ; i++ {
+			elem, ok := v.Recv()
+			if !ok {
+				return dst, n, nil
+			}
+			mark := len(dst)
+			var err error
+			if dst, err = appendSliceElem(dst, elem, start, opts); err != nil {
+				if err = opts.handle(ErrorSkipPoint, fmt.Errorf("element %d: %w", i, err)); err != nil {
+					drain(v)
+					return dst[:start], 0, err
+				}
+				dst = dst[:mark]
+				continue
+			}
+			n++
+		}
+	}
+	return dst, 0, fmt.Errorf("%w: %s", ErrUnsupportedType, v.Type())
+}
+
+// drain receives the rest values of channel ch until it is closed, so its sender
+// is not blocked after the encoding failed.
+func drain(ch reflect.Value) {
+	for {
+		if _, ok := ch.Recv(); !ok {
+			return
+		}
+	}
+}
+
+// isStructElem reports whether the elements of type t may be encoded: structs,
+// pointers to structs or interfaces which values are checked at encoding.
+func isStructElem(t reflect.Type) bool {
+	t = indirectType(t)
+	return t.Kind() == reflect.Struct || t.Kind() == reflect.Interface
+}
+
+// appendSliceElem appends the row of element of slice, array or channel to dst,
+// the rows are separated by newlines starting from the start position.
+func appendSliceElem(dst []byte, elem reflect.Value, start int, opts encOpts) ([]byte, error) {
+	v, ok := indirect(elem)
+	if !ok {
+		return dst, fmt.Errorf("%w: nil %s", ErrUnsupportedType, elem.Type())
+	}
+	if v.Kind() != reflect.Struct {
+		return dst, fmt.Errorf("%w: %s", ErrUnsupportedType, v.Type())
+	}
+	p, err := planOf(v.Type(), opts)
+	if err != nil {
+		return dst, err
+	}
+	if len(dst) > start {
+		dst = append(dst, '\n')
+	}
+	return p.appendLine(dst, v, opts)
+}
+
+// appendLine appends the line protocol row of struct value v to dst.
+func (p *typePlan) appendLine(dst []byte, v reflect.Value, opts encOpts) ([]byte, error) {
+	var measurement, measurementField string
+	var timestamp time.Time
+	precision := opts.precision
+
+	if p.lineMarshaler != noMethod {
+		b, err := p.lineMarshaler.recv(v).Interface().(LineMarshaler).MarshalInfluxLine()
+		if err == nil {
+			err = checkLine(b)
+		} else {
+			err = fmt.Errorf("%w: %w", ErrMarshalInflux, err)
+		}
+		if err != nil {
+			return dst, &FieldError{Struct: p.typ.String(), Field: "MarshalInfluxLine()", Err: err}
+		}
+		return append(dst, bytes.TrimSuffix(b, []byte{'\n'})...), nil
+	}
+
+	if p.measurementer != noMethod {
+		measurement = p.measurementer.recv(v).Interface().(Measurementer).InfluxMeasurement()
+		measurementField = "InfluxMeasurement()"
+	}
+	if p.timestamper != noMethod {
+		timestamp = p.timestamper.recv(v).Interface().(Timestamper).InfluxTimestamp()
+	}
+
+	e := newEncodeState(p, opts)
+	defer e.release()
+
+	for i := range p.fields {
+		f := &p.fields[i]
+		fv, ok := fieldByIndex(v, f.index)
+		if !ok {
+			continue // the fields of nil embedded structs are omitted
+		}
+		if f.omitEmpty && fv.IsZero() {
+			continue
+		}
+		if fv, ok = indirect(fv); !ok {
+			continue // nil pointers and interfaces are omitted
+		}
+
+		var err error
+		switch f.kind {
+		case kindTag, kindField:
+			err = e.appendElem(f, f.key, fv)
+		case kindTags, kindFields:
+			err = e.appendMap(f, fv)
+		case kindMeasurement:
+			if fv.Kind() == reflect.String {
+				measurement = fv.String()
+			} else {
+				measurement = fmt.Sprint(fv)
+			}
+			measurementField = f.field
+		case kindTimestamp:
+			if timestamp, err = f.timestampOf(fv); err != nil {
+				return dst, e.fieldError(f, err)
+			}
+			if f.precision != 0 {
+				precision = f.precision
+			}
+		}
+		if err != nil {
+			return dst, err
+		}
+	}
+
+	if p.tagger != noMethod {
+		tags := p.tagger.recv(v).Interface().(Tagger).InfluxTags()
+		if err := e.appendMap(&p.hookTags, reflect.ValueOf(tags)); err != nil {
+			return dst, err
+		}
+	}
+	if p.fielder != noMethod {
+		fields := p.fielder.recv(v).Interface().(Fielder).InfluxFields()
+		if err := e.appendMap(&p.hookFields, reflect.ValueOf(fields)); err != nil {
+			return dst, err
+		}
+	}
+
+	if measurement == "" {
+		return dst, ErrMissingMeasurement
+	}
+
+	if timestamp.IsZero() {
+		switch {
+		case opts.now != nil:
+			timestamp = opts.now()
+		case !opts.optionalTimestamp && !p.optionalTimestamp:
+			return dst, ErrMissingTimestamp
+		}
+	}
+
+	if len(e.fields) == 0 {
+		return dst, ErrNoFields
+	}
+
+	if err := validateMeasurement(measurement); err != nil {
+		if opts.validation == ValidationReplace {
+			measurement, err = replaceMeasurement(measurement, opts.placeholder)
+		}
+		if err != nil {
+			return dst, &FieldError{
+				Struct: p.typ.String(), Field: measurementField,
+				Err: fmt.Errorf("%w of measurement %q", err, measurement),
+			}
+		}
+	}
+
+	dst = appendMeasurement(dst, measurement)
+	if opts.sortTags && len(e.tagSpans) > 1 {
+		slices.SortStableFunc(e.tagSpans, func(a, b tagSpan) int {
+			return strings.Compare(a.key, b.key)
+		})
+		for _, t := range e.tagSpans {
+			dst = append(dst, e.tags[t.start:t.end]...)
+		}
+	} else {
+		dst = append(dst, e.tags...)
+	}
+	dst = append(dst, ' ')
+	dst = append(dst, e.fields...)
+	if !timestamp.IsZero() {
+		dst = append(dst, ' ')
+		dst = appendTimestamp(dst, timestamp, precision)
+	}
+	return dst, nil
+}
+
+// checkLine checks the row returned by MarshalInfluxLine, it must be exactly one
+// row: not empty and having no newlines other than in string field values and
+// the trailing one. The row is parsed only if it has such newlines.
+func checkLine(b []byte) error {
+	b = bytes.TrimSuffix(b, []byte{'\n'})
+	if len(b) == 0 {
+		return ErrNoFields
+	}
+	if bytes.IndexByte(b, '\n') >= 0 {
+		if _, err := parseLine(b); err != nil {
+			return fmt.Errorf("%w outside of string values", ErrNewline)
+		}
+	}
+	return nil
+}
+
+// appendTimestamp appends the Unix time t truncated to precision to dst,
+// the precisions other than seconds, milliseconds and microseconds mean nanoseconds.
+func appendTimestamp(dst []byte, t time.Time, precision time.Duration) []byte {
+	return strconv.AppendInt(dst, unixValue(t, precision), 10)
+}
+
+// unixValue returns the Unix time t in given precision, the precisions other than
+// seconds, milliseconds and microseconds mean nanoseconds.
+func unixValue(t time.Time, precision time.Duration) int64 {
+	switch precision {
+	case time.Second:
+		return t.Unix()
+	case time.Millisecond:
+		return t.UnixMilli()
+	case time.Microsecond:
+		return t.UnixMicro()
+	}
+	return t.UnixNano()
+}
+
+// appendElem appends the tag or field of plan f with the key to the row,
+// the invalid keys and values are handled according to validation policy.
+func (e *encodeState) appendElem(f *fieldPlan, key string, fv reflect.Value) error {
+	name := f.name
+	if key != f.key {
+		name = escapeTagKVFieldK(key)
+	}
+	if err := validateKey(key); err != nil {
+		switch e.opts.validation {
+		case ValidationDrop:
+			return nil
+		case ValidationReplace:
+			if key, err = replaceKey(key, e.opts.placeholder); err == nil {
+				name = escapeTagKVFieldK(key)
+				break
+			}
+			fallthrough
+		default:
+			return e.fieldError(f, fmt.Errorf("%w of %s %q", err, f.kind, key))
+		}
+	}
+
+	buf := &e.fields
+	if f.kind == kindTag {
+		buf = &e.tags
+	}
+
+	mark := len(*buf)
+	if f.kind == kindTag || mark > 0 {
+		*buf = append(*buf, ',')
+	}
+	*buf = append(*buf, name...)
+	*buf = append(*buf, '=')
+
+	start := len(*buf)
+	var err error
+	if *buf, err = f.appendValue(*buf, fv, e.opts.floatPoint); err != nil {
+		*buf = (*buf)[:mark]
+		if errors.Is(err, ErrNonFiniteFloat) {
+			if e.opts.validation != ValidationError {
+				return nil // there is no replacement of NaN and Inf
+			}
+			return e.fieldError(f, fmt.Errorf("%w of %s %q", err, f.kind, key))
+		}
+		if errors.Is(err, ErrLossyConversion) || errors.Is(err, ErrUnsupportedType) {
+			if e.opts.logFieldErrors {
+				log.Printf("%s %q: %s", f.kind, key, err)
+				return nil
+			}
+			return e.opts.handle(ErrorSkipField, e.fieldError(f, fmt.Errorf("%s %q: %w", f.kind, key, err)))
+		}
+		if e.opts.logFieldErrors {
+			log.Printf("%s %q MarshalInflux error: %s", f.kind, key, err)
+			return nil
+		}
+		return e.opts.handle(ErrorSkipField, e.fieldError(f, fmt.Errorf("%w: %s %q: %w", ErrMarshalInflux, f.kind, key, err)))
+	}
+
+	if len(*buf) == start {
+		switch {
+		case e.opts.validation == ValidationDrop:
+			*buf = (*buf)[:mark]
+			return nil
+		case e.opts.validation == ValidationReplace && e.opts.placeholder != "":
+			if f.kind == kindTag {
+				*buf = appendKey(*buf, e.opts.placeholder)
+			} else {
+				*buf = appendFieldV(*buf, e.opts.placeholder)
+			}
+		default:
+			*buf = (*buf)[:mark]
+			return e.fieldError(f, fmt.Errorf("%w of %s %q", ErrEmptyValue, f.kind, key))
+		}
+	}
+
+	if f.kind == kindTag && bytes.IndexByte((*buf)[start:], '\n') >= 0 {
+		*buf = (*buf)[:mark]
+		if e.opts.validation == ValidationDrop {
+			return nil
+		}
+		return e.fieldError(f, fmt.Errorf("%w in value of %s %q", ErrNewline, f.kind, key))
+	}
+
+	if f.kind == kindTag {
+		e.tagSpans = append(e.tagSpans, tagSpan{key: key, start: mark, end: len(e.tags)})
+	}
+	return nil
+}
+
+// appendMap appends the map of tags or fields to the row sorted by keys,
+// the nil values are omitted.
+func (e *encodeState) appendMap(f *fieldPlan, fv reflect.Value) error {
+	e.keys = e.keys[:0]
+	for _, k := range fv.MapKeys() {
+		e.keys = append(e.keys, k.String())
+	}
+	slices.Sort(e.keys)
+
+	for _, k := range e.keys {
+		ev := fv.MapIndex(reflect.ValueOf(k).Convert(fv.Type().Key()))
+		if ev.Kind() == reflect.Interface {
+			ev = ev.Elem() // the dynamic value is checked for emptiness
+		}
+		if f.elem.omitEmpty && ev.IsValid() && ev.IsZero() {
+			continue
+		}
+		ev, ok := indirect(ev)
+		if !ok || !ev.IsValid() {
+			continue
+		}
+		if err := e.appendElem(f.elem, f.key+k, ev); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appendValue appends the value of tag or field to dst, the returned error
+// is the error of MarshalInflux or MarshalInfluxValue method or ErrNonFiniteFloat.
+// The floatPoint makes the integer floats to be written with decimal point.
+func (f *fieldPlan) appendValue(dst []byte, fv reflect.Value, floatPoint bool) ([]byte, error) {
+	info := f.valueInfo
+	if f.dynamic {
+		var err error
+		if info, err = newValueInfo(fv.Type()); err != nil {
+			return dst, err
+		}
+		if !info.encodes(fv.Type()) {
+			return dst, fmt.Errorf("%w: %s", ErrUnsupportedType, fv.Type())
+		}
+	}
+
+	if f.coerce != InvalidValue || f.unit != 0 || f.dynamic && fv.Type() == durationType {
+		v, err := f.valueOf(fv, info)
+		if err == nil && f.coerce != InvalidValue {
+			v, err = v.convert(f.coerce)
+		}
+		if err != nil {
+			return dst, err
+		}
+		return v.appendTo(dst, f.kind, floatPoint)
+	}
+
+	if info.valueMarshaler != noMethod {
+		v, err := info.valueMarshaler.recv(fv).Interface().(ValueMarshaler).MarshalInfluxValue()
+		if err != nil {
+			return dst, err
+		}
+		return v.appendTo(dst, f.kind, floatPoint)
+	}
+
+	if info.marshaler != noMethod {
+		s, err := info.marshaler.recv(fv).Interface().(Marshaler).MarshalInflux()
+		if err != nil {
+			return dst, err
+		}
+		return append(dst, s...), nil
+	}
+
+	switch fv.Kind() {
+	case reflect.String:
+		if f.kind == kindTag {
+			return appendKey(dst, fv.String()), nil
+		}
+		return appendFieldV(dst, fv.String()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return appendInt(dst, fv.Int(), f.kind), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return appendUint(dst, fv.Uint(), f.kind), nil
+	case reflect.Float32:
+		return appendFloat(dst, fv.Float(), 32, -1, floatPoint)
+	case reflect.Float64:
+		return appendFloat(dst, fv.Float(), 64, -1, floatPoint)
+	case reflect.Bool:
+		return strconv.AppendBool(dst, fv.Bool()), nil
+	}
+	// the types formatted by fmt package, e.g. time.Time or net.IP, are written
+	// as strings: escaped in tags and quoted in fields
+	return String(fmt.Sprint(fv)).appendTo(dst, f.kind, floatPoint)
+}
+
+// valueOf returns the typed value of tag or field value fv, the output of MarshalInflux
+// method and the values of kinds other than numbers, booleans and strings (formatted
+// by fmt package) are strings, time.Duration is converted to the unit of plan.
+func (f *fieldPlan) valueOf(fv reflect.Value, info valueInfo) (Value, error) {
+	if info.valueMarshaler != noMethod {
+		return info.valueMarshaler.recv(fv).Interface().(ValueMarshaler).MarshalInfluxValue()
+	}
+	if info.marshaler != noMethod {
+		s, err := info.marshaler.recv(fv).Interface().(Marshaler).MarshalInflux()
+		return String(s), err
+	}
+
+	if fv.Type() == durationType {
+		return f.durationValue(time.Duration(fv.Int())), nil
+	}
+
+	switch fv.Kind() {
+	case reflect.String:
+		return String(fv.String()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return Int(fv.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return Uint(fv.Uint()), nil
+	case reflect.Float32:
+		return float32Value(float32(fv.Float())), nil
+	case reflect.Float64:
+		return Float(fv.Float()), nil
+	case reflect.Bool:
+		return Bool(fv.Bool()), nil
+	}
+	return String(fmt.Sprint(fv)), nil
+}
+
+// appendFloat appends the float f of given bit size to dst. The prec >= 0 is the number
+// of digits after the decimal point, otherwise the shortest representation which is
+// parsed back to the same value is used, the exponent form is used for very small and
+// very large numbers only, like encoding/json does. The floatPoint makes the integer
+// values to be written with decimal point, e.g. 1.0 instead of 1. The NaN and Inf are
+// not allowed by line protocol, ErrNonFiniteFloat is returned for them.
+func appendFloat(dst []byte, f float64, bits, prec int, floatPoint bool) ([]byte, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return dst, ErrNonFiniteFloat
+	}
+
+	start := len(dst)
+	if prec >= 0 {
+		dst = strconv.AppendFloat(dst, f, 'f', prec, bits)
+	} else {
+		format := byte('f')
+		if abs := math.Abs(f); abs != 0 {
+			if bits == 64 && (abs < 1e-6 || abs >= 1e21) ||
+				bits == 32 && (float32(abs) < 1e-6 || float32(abs) >= 1e21) {
+				format = 'e'
+			}
+		}
+		dst = strconv.AppendFloat(dst, f, format, -1, bits)
+		if format == 'e' {
+			// clean up e-09 to e-9
+			if n := len(dst); n-start >= 4 && dst[n-4] == 'e' && dst[n-3] == '-' && dst[n-2] == '0' {
+				dst[n-2] = dst[n-1]
+				dst = dst[:n-1]
+			}
+			return dst, nil
+		}
+	}
+	if floatPoint && bytes.IndexByte(dst[start:], '.') < 0 {
+		dst = append(dst, ".0"...)
+	}
+	return dst, nil
+}
@@ -0,0 +1,1336 @@
+package influx
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+type benchPoint struct {
+	Operation     string    `influx:",measurement"`
+	DataCenter    string    `influx:"datacenter,tag,omitempty"`
+	CloudProvider string    `influx:"cloud,tag,omitempty"`
+	Errors        int       `influx:"errors,field"`
+	Processed     uint64    `influx:"processed,field"`
+	Rate          float64   `influx:"rate,field"`
+	Timestamp     time.Time `influx:",timestamp"`
+}
+
+func TestCachedTypePlan(t *testing.T) {
+	t.Parallel()
+
+	t.Run("cached", func(t *testing.T) {
+		typ := reflect.TypeFor[benchPoint]()
+		p1, _ := cachedTypePlan(typ)
+		p2, _ := cachedTypePlan(typ)
+		if p1 != p2 {
+			t.Error("expected the same plan for the same type")
+		}
+	})
+
+	t.Run("fields", func(t *testing.T) {
+		p, err := cachedTypePlan(reflect.TypeFor[TestMarshal]())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(p.fields) != 4 {
+			t.Fatalf("expected 4 fields, got: %d", len(p.fields))
+		}
+		if p.fields[3].marshaler != valueMethod {
+			t.Error("expected marshaler of SpecialString field")
+		}
+		if p.measurementer != noMethod || p.timestamper != noMethod {
+			t.Error("unexpected measurementer or timestamper")
+		}
+	})
+
+	t.Run("concurrent", func(t *testing.T) {
+		ts := time.Now()
+		v := benchPoint{
+			Operation: "backup", DataCenter: "east-1", CloudProvider: "AWS",
+			Errors: 2, Processed: 100, Rate: 0.5, Timestamp: ts,
+		}
+		expected := "backup,cloud=AWS,datacenter=east-1 errors=2i,processed=100u,rate=0.5 " +
+			strconv.FormatInt(ts.UnixNano(), 10)
+
+		var wg sync.WaitGroup
+		for range 8 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for range 100 {
+					row, err := Marshal(v)
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					if expected != string(row) {
+						t.Errorf("expected: %s, got: %s", expected, row)
+						return
+					}
+				}
+			}()
+		}
+		wg.Wait()
+	})
+
+	t.Run("stringer", func(t *testing.T) {
+		ts := time.Now()
+		v := struct {
+			Name   string        `influx:",measurement"`
+			Uptime time.Duration `influx:"uptime,field"`
+			Ts     time.Time     `influx:",timestamp"`
+		}{Name: "node", Uptime: time.Minute, Ts: ts}
+
+		expected := "node uptime=60000000000i " + strconv.FormatInt(ts.UnixNano(), 10)
+		row := ConvertToInfluxLineProtocol(v)
+		if expected != row {
+			t.Errorf("expected: %s, got: %s", expected, row)
+		}
+	})
+}
+
+func BenchmarkMarshal(b *testing.B) {
+	v := benchPoint{
+		Operation: "backup", DataCenter: "east-1", CloudProvider: "AWS",
+		Errors: 2, Processed: 100, Rate: 0.5, Timestamp: time.Now(),
+	}
+	b.ReportAllocs()
+	for range b.N {
+		if _, err := Marshal(v); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkMarshalUncached compiles the plan on every call, which is
+// what the encoder did before the plans were cached.
+func BenchmarkMarshalUncached(b *testing.B) {
+	v := benchPoint{
+		Operation: "backup", DataCenter: "east-1", CloudProvider: "AWS",
+		Errors: 2, Processed: 100, Rate: 0.5, Timestamp: time.Now(),
+	}
+	b.ReportAllocs()
+	for range b.N {
+		p := newTypePlan(reflect.TypeOf(v))
+		if _, err := p.appendLine(nil, reflect.ValueOf(v), encOpts{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestAppendLines(t *testing.T) {
+	t.Parallel()
+
+	ts := time.Now()
+	points := []benchPoint{
+		{Operation: "backup", DataCenter: "east-1", CloudProvider: "AWS", Errors: 1, Timestamp: ts},
+		{Operation: "restore", DataCenter: "west-1", CloudProvider: "GCP", Errors: 2, Timestamp: ts},
+	}
+	expected := "backup,cloud=AWS,datacenter=east-1 errors=1i,processed=0u,rate=0 " +
+		strconv.FormatInt(ts.UnixNano(), 10) + "\n" +
+		"restore,cloud=GCP,datacenter=west-1 errors=2i,processed=0u,rate=0 " +
+		strconv.FormatInt(ts.UnixNano(), 10)
+
+	channel := func() chan benchPoint {
+		ch := make(chan benchPoint, len(points))
+		for _, p := range points {
+			ch <- p
+		}
+		close(ch)
+		return ch
+	}
+
+	testCases := []struct {
+		Name   string
+		Sample any
+	}{
+		{Name: "slice", Sample: points},
+		{Name: "array", Sample: [2]benchPoint{points[0], points[1]}},
+		{Name: "pointers", Sample: []*benchPoint{&points[0], &points[1]}},
+		{Name: "chan", Sample: channel()},
+		{Name: "chan/recv", Sample: (<-chan benchPoint)(channel())},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			rows, err := Marshal(testCase.Sample)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected != string(rows) {
+				t.Errorf("expected: %s, got: %s", expected, rows)
+			}
+		})
+	}
+
+	t.Run("empty", func(t *testing.T) {
+		rows, err := Marshal([]benchPoint{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rows) != 0 {
+			t.Errorf("expected empty, got: %s", rows)
+		}
+	})
+
+	t.Run("error/element", func(t *testing.T) {
+		_, err := Marshal([]benchPoint{points[0], {Timestamp: ts}})
+		if !errors.Is(err, ErrMissingMeasurement) {
+			t.Errorf("expected ErrMissingMeasurement, got: %v", err)
+		}
+		if err == nil || !strings.HasPrefix(err.Error(), "element 1: ") {
+			t.Errorf("expected element index in error, got: %v", err)
+		}
+	})
+
+	t.Run("error/chan", func(t *testing.T) {
+		ch := make(chan benchPoint)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			ch <- benchPoint{Operation: "backup", Errors: 1} // no timestamp
+			ch <- points[0]
+			close(ch)
+		}()
+
+		if _, err := Marshal(ch); !errors.Is(err, ErrMissingTimestamp) {
+			t.Errorf("expected ErrMissingTimestamp, got: %v", err)
+		}
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("sender blocked")
+		}
+	})
+
+	t.Run("error/unsupported", func(t *testing.T) {
+		for _, sample := range []any{
+			nil,
+			12,
+			"row",
+			[]int{1},
+			[]*benchPoint{nil},
+			make(chan<- benchPoint),
+		} {
+			if _, err := Marshal(sample); !errors.Is(err, ErrUnsupportedType) {
+				t.Errorf("expected ErrUnsupportedType for %T, got: %v", sample, err)
+			}
+		}
+	})
+}
+
+type TestPointers struct {
+	Name      *string    `influx:",measurement"`
+	Host      *string    `influx:"host,tag"`
+	Region    any        `influx:"region,tag"`
+	Errors    *int       `influx:"errors,field"`
+	Rate      **float64  `influx:"rate,field"`
+	Sensor    any        `influx:"sensor,field"`
+	Weight    *int64     `influx:"weight,field"`
+	Timestamp *time.Time `influx:",timestamp"`
+}
+
+func TestPointersAndInterfaces(t *testing.T) {
+	t.Parallel()
+
+	ts := time.Now()
+	name, host, errs, rate := "node", "web-1", 12, 0.5
+	ratePtr := &rate
+
+	t.Run("fields", func(t *testing.T) {
+		v := TestPointers{
+			Name: &name, Host: &host, Region: "eu", Errors: &errs, Rate: &ratePtr,
+			Sensor: SpecialString("onboard,45.16"), Timestamp: &ts,
+		}
+		expected := "node,host=web-1,region=eu errors=12i,rate=0.5,sensor=45.16 " +
+			strconv.FormatInt(ts.UnixNano(), 10)
+
+		row, err := Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected != string(row) {
+			t.Errorf("expected: %s, got: %s", expected, row)
+		}
+	})
+
+	t.Run("nil", func(t *testing.T) {
+		v := TestPointers{Name: &name, Errors: &errs, Timestamp: &ts}
+		expected := "node errors=12i " + strconv.FormatInt(ts.UnixNano(), 10)
+
+		row, err := Marshal(&v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected != string(row) {
+			t.Errorf("expected: %s, got: %s", expected, row)
+		}
+
+		if _, err := Marshal(&TestPointers{Errors: &errs, Timestamp: &ts}); !errors.Is(err, ErrMissingMeasurement) {
+			t.Errorf("expected ErrMissingMeasurement, got: %v", err)
+		}
+		if _, err := Marshal(&TestPointers{Name: &name, Errors: &errs}); !errors.Is(err, ErrMissingTimestamp) {
+			t.Errorf("expected ErrMissingTimestamp, got: %v", err)
+		}
+	})
+
+	t.Run("top-level", func(t *testing.T) {
+		v := benchPoint{Operation: "backup", Errors: 1, Timestamp: ts}
+		expected := "backup errors=1i,processed=0u,rate=0 " +
+			strconv.FormatInt(ts.UnixNano(), 10)
+
+		pv := &v
+		for _, sample := range []any{&v, &pv, []any{v}, []any{&v}, &[]benchPoint{v}} {
+			row, err := Marshal(sample)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected != string(row) {
+				t.Errorf("expected: %s, got: %s", expected, row)
+			}
+		}
+		if row := ConvertToInfluxLineProtocol(&v); expected != row {
+			t.Errorf("expected: %s, got: %s", expected, row)
+		}
+	})
+
+	t.Run("error/nil", func(t *testing.T) {
+		for _, sample := range []any{(*benchPoint)(nil), []any{nil}, []any{12}} {
+			if _, err := Marshal(sample); !errors.Is(err, ErrUnsupportedType) {
+				t.Errorf("expected ErrUnsupportedType for %T, got: %v", sample, err)
+			}
+		}
+	})
+}
+
+type CommonTags struct {
+	Region  string `influx:"region,tag"`
+	Host    string `influx:"host,tag"`
+	Service string `influx:"service,tag"`
+}
+
+type NetStats struct {
+	Errors  int    `influx:"errors,field"`
+	Packets uint64 `influx:"packets,field"`
+	Iface   string `influx:"iface,tag"`
+}
+
+type TestNested struct {
+	CommonTags
+	*Meta
+	Name string      `influx:",measurement"`
+	Net  NetStats    `influx:"net_,prefix"`
+	Disk *DiskStats  `influx:"disk_,prefix"`
+	Ts   time.Time   `influx:",timestamp"`
+	Skip NetStats    // not tagged, not flattened
+	Load int         `influx:"load,field"`
+	Tags *CommonTags `influx:"other_,prefix"`
+}
+
+type Meta struct {
+	Version string `influx:"version,tag"`
+}
+
+type DiskStats struct {
+	Used  uint64   `influx:"used,field"`
+	Inner NetStats `influx:"sda_,prefix"`
+}
+
+type TestCycle struct {
+	Name string     `influx:",measurement"`
+	Next *TestCycle `influx:"next_,prefix"`
+}
+
+type TestPrefixNonStruct struct {
+	Name string `influx:",measurement"`
+	Load int    `influx:"load_,prefix"`
+}
+
+func TestNestedStructs(t *testing.T) {
+	t.Parallel()
+
+	ts := time.Now()
+
+	t.Run("flatten", func(t *testing.T) {
+		v := TestNested{
+			CommonTags: CommonTags{Region: "eu", Host: "web-1", Service: "api"},
+			Meta:       &Meta{Version: "v1.2"},
+			Name:       "node",
+			Net:        NetStats{Errors: 1, Packets: 200, Iface: "eth0"},
+			Disk:       &DiskStats{Used: 1024, Inner: NetStats{Errors: 2, Packets: 3, Iface: "sata"}},
+			Ts:         ts,
+			Load:       5,
+		}
+		expected := "node,disk_sda_iface=sata,host=web-1,net_iface=eth0,region=eu,service=api,version=v1.2 " +
+			"net_errors=1i,net_packets=200u,disk_used=1024u,disk_sda_errors=2i,disk_sda_packets=3u,load=5i " +
+			strconv.FormatInt(ts.UnixNano(), 10)
+
+		row, err := Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected != string(row) {
+			t.Errorf("expected: %s, got: %s", expected, row)
+		}
+
+		var got TestNested
+		if err := Unmarshal(row, &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Meta == nil || got.Meta.Version != "v1.2" || got.Disk == nil || got.Disk.Inner != v.Disk.Inner {
+			t.Errorf("expected: %+v, got: %+v", v, got)
+		}
+	})
+
+	t.Run("nil", func(t *testing.T) {
+		v := TestNested{
+			CommonTags: CommonTags{Region: "eu", Host: "web-1", Service: "api"},
+			Name:       "node", Net: NetStats{Iface: "lo"}, Ts: ts, Load: 5,
+		}
+		expected := "node,host=web-1,net_iface=lo,region=eu,service=api net_errors=0i,net_packets=0u,load=5i " +
+			strconv.FormatInt(ts.UnixNano(), 10)
+
+		row, err := Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected != string(row) {
+			t.Errorf("expected: %s, got: %s", expected, row)
+		}
+	})
+
+	t.Run("error/cycle", func(t *testing.T) {
+		_, err := Marshal(TestCycle{Name: "node"})
+		if !errors.Is(err, ErrUnsupportedType) {
+			t.Errorf("expected ErrUnsupportedType, got: %v", err)
+		}
+		if err == nil || !strings.Contains(err.Error(), "influx.TestCycle.Next: unsupported type: cyclic nesting") {
+			t.Errorf("expected cycle error, got: %v", err)
+		}
+	})
+
+	t.Run("error/prefix", func(t *testing.T) {
+		_, err := Marshal(TestPrefixNonStruct{Name: "node"})
+		if !errors.Is(err, ErrUnsupportedType) {
+			t.Errorf("expected ErrUnsupportedType, got: %v", err)
+		}
+	})
+}
+
+type TestMaps struct {
+	Name   string            `influx:",measurement"`
+	Host   string            `influx:"host,tag"`
+	Labels map[string]string `influx:",tags"`
+	Load   int               `influx:"load,field"`
+	Values map[string]any    `influx:",fields"`
+	Net    struct {
+		Counters map[string]uint64 `influx:"cnt_,fields"`
+	} `influx:"net_,prefix"`
+	Ts time.Time `influx:",timestamp"`
+}
+
+func TestMapFields(t *testing.T) {
+	t.Parallel()
+
+	ts := time.Now()
+
+	t.Run("ok", func(t *testing.T) {
+		v := TestMaps{
+			Name:   "requests",
+			Host:   "web-1",
+			Labels: map[string]string{"path": "/api/v1", "method": "GET", "user agent": "curl, 8.0"},
+			Load:   3,
+			Values: map[string]any{
+				"status": 200,
+				"bytes":  uint32(512),
+				"time":   0.25,
+				"cached": true,
+				"error":  `not "found"`,
+				"sensor": SpecialString("onboard,45.16"),
+				"empty":  nil,
+				"ptr":    (*int)(nil),
+			},
+			Ts: ts,
+		}
+		v.Net.Counters = map[string]uint64{"rx": 10, "tx": 20}
+
+		expected := `requests,host=web-1,method=GET,path=/api/v1,user\ agent=curl\,\ 8.0 ` +
+			`load=3i,bytes=512u,cached=true,error="not \"found\"",sensor=45.16,status=200i,time=0.25,` +
+			`net_cnt_rx=10u,net_cnt_tx=20u ` + strconv.FormatInt(ts.UnixNano(), 10)
+
+		row, err := Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected != string(row) {
+			t.Errorf("expected: %s, got: %s", expected, row)
+		}
+	})
+
+	t.Run("nil", func(t *testing.T) {
+		v := TestMaps{Name: "requests", Host: "web-1", Load: 3, Ts: ts}
+		expected := "requests,host=web-1 load=3i " + strconv.FormatInt(ts.UnixNano(), 10)
+
+		row, err := Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected != string(row) {
+			t.Errorf("expected: %s, got: %s", expected, row)
+		}
+	})
+
+	t.Run("error/marshal", func(t *testing.T) {
+		v := TestMaps{
+			Name: "requests", Host: "web-1", Load: 3, Ts: ts,
+			Values: map[string]any{"sensor": SpecialString("broken")},
+		}
+		if _, err := Marshal(v); !errors.Is(err, ErrMarshalInflux) {
+			t.Errorf("expected ErrMarshalInflux, got: %v", err)
+		}
+	})
+
+	t.Run("error/type", func(t *testing.T) {
+		for _, sample := range []any{
+			struct {
+				Tags []string `influx:",tags"`
+			}{},
+			struct {
+				Fields map[int]string `influx:",fields"`
+			}{},
+		} {
+			if _, err := Marshal(sample); !errors.Is(err, ErrUnsupportedType) {
+				t.Errorf("expected ErrUnsupportedType for %T, got: %v", sample, err)
+			}
+		}
+	})
+}
+
+func TestOmitEmpty(t *testing.T) {
+	t.Parallel()
+
+	ts := time.Now()
+	zero := 0
+
+	type sample struct {
+		Name    string         `influx:",measurement"`
+		DC      string         `influx:"dc,tag,omitempty"`
+		Cloud   string         `influx:"cloud,tag"`
+		Errors  int            `influx:"errors,field,omitempty"`
+		Retries *int           `influx:"retries,field,omitempty"`
+		Rate    float64        `influx:"rate,field"`
+		Labels  map[string]any `influx:",fields,omitempty"`
+		Ts      time.Time      `influx:",timestamp"`
+	}
+
+	testCases := []struct {
+		Name     string
+		Sample   sample
+		Expected string
+	}{
+		{
+			Name:     "empty",
+			Sample:   sample{Name: "node", Retries: nil, Labels: map[string]any{"a": 0, "b": "", "c": nil}},
+			Expected: "node,cloud=AWS rate=0",
+		},
+		{
+			Name:     "pointer",
+			Sample:   sample{Name: "node", Retries: &zero},
+			Expected: "node,cloud=AWS retries=0i,rate=0",
+		},
+		{
+			Name:     "set",
+			Sample:   sample{Name: "node", DC: "east-1", Errors: 2, Labels: map[string]any{"a": 1}},
+			Expected: "node,cloud=AWS,dc=east-1 errors=2i,rate=0,a=1i",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			testCase.Sample.Cloud = "AWS"
+			testCase.Sample.Ts = ts
+			expected := testCase.Expected + " " + strconv.FormatInt(ts.UnixNano(), 10)
+			row, err := Marshal(testCase.Sample)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected != string(row) {
+				t.Errorf("expected: %s, got: %s", expected, row)
+			}
+		})
+	}
+}
+
+func TestSortTags(t *testing.T) {
+	t.Parallel()
+
+	ts := time.Now()
+	v := TestMaps{
+		Name: "requests", Host: "web-1", Load: 1, Ts: ts,
+		Labels: map[string]string{"zone": "b", "app": "api"},
+	}
+	suffix := " load=1i " + strconv.FormatInt(ts.UnixNano(), 10)
+
+	row, err := Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "requests,app=api,host=web-1,zone=b" + suffix; expected != string(row) {
+		t.Errorf("expected: %s, got: %s", expected, row)
+	}
+
+	// the order of struct fields is kept for compatibility
+	if expected := "requests,host=web-1,app=api,zone=b" + suffix; expected != ConvertToInfluxLineProtocol(v) {
+		t.Errorf("expected: %s, got: %s", expected, ConvertToInfluxLineProtocol(v))
+	}
+
+	var buf strings.Builder
+	enc := NewEncoder(&buf)
+	enc.SetSortTags(false)
+	if err := enc.Encode(v); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "requests,host=web-1,app=api,zone=b" + suffix + "\n"; expected != buf.String() {
+		t.Errorf("expected: %s, got: %s", expected, buf.String())
+	}
+}
+
+// uploadTime is the timestamp type implementing Timestamper.
+type uploadTime struct{ started, elapsed int64 }
+
+func (u uploadTime) InfluxTimestamp() time.Time { return time.Unix(u.started+u.elapsed, 0) }
+
+type TestTimestamps struct {
+	Name     string       `influx:",measurement"`
+	Errors   int          `influx:"errors,field"`
+	Unix     int64        `influx:",timestamp,unit=ms,omitempty"`
+	Ptr      *time.Time   `influx:",timestamp,omitempty"`
+	NullTime sql.NullTime `influx:",timestamp,omitempty"`
+	Upload   *uploadTime  `influx:",timestamp,omitempty"`
+}
+
+func TestTimestampTypes(t *testing.T) {
+	t.Parallel()
+
+	ts := time.Date(2024, time.April, 10, 23, 23, 23, 123456789, time.UTC)
+
+	testCases := []struct {
+		Name     string
+		Sample   TestTimestamps
+		Expected string
+	}{
+		{
+			Name:     "int64",
+			Sample:   TestTimestamps{Unix: ts.UnixMilli()},
+			Expected: "cpu errors=1i 1712791403123000000",
+		},
+		{
+			Name:     "pointer",
+			Sample:   TestTimestamps{Ptr: &ts},
+			Expected: "cpu errors=1i 1712791403123456789",
+		},
+		{
+			Name:     "sql.NullTime",
+			Sample:   TestTimestamps{NullTime: sql.NullTime{Time: ts, Valid: true}},
+			Expected: "cpu errors=1i 1712791403123456789",
+		},
+		{
+			Name:     "sql.NullTime/invalid",
+			Sample:   TestTimestamps{NullTime: sql.NullTime{Time: ts}},
+			Expected: "cpu errors=1i",
+		},
+		{
+			Name:     "Timestamper",
+			Sample:   TestTimestamps{Upload: &uploadTime{started: 1712791400, elapsed: 3}},
+			Expected: "cpu errors=1i 1712791403000000000",
+		},
+		{
+			Name:     "none",
+			Sample:   TestTimestamps{},
+			Expected: "cpu errors=1i",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			v := testCase.Sample
+			v.Name, v.Errors = "cpu", 1
+			row, err := Marshal(v)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if testCase.Expected != string(row) {
+				t.Errorf("expected: %s, got: %s", testCase.Expected, row)
+			}
+		})
+	}
+
+	t.Run("decode", func(t *testing.T) {
+		var got struct {
+			Unix     int64        `influx:",timestamp,unit=ms"`
+			Ptr      *time.Time   `influx:",timestamp"`
+			NullTime sql.NullTime `influx:",timestamp"`
+		}
+		if err := Unmarshal([]byte("cpu errors=1i 1712791403123456789"), &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Unix != ts.UnixMilli() {
+			t.Errorf("expected: %d, got: %d", ts.UnixMilli(), got.Unix)
+		}
+		if got.Ptr == nil || !got.Ptr.Equal(ts) {
+			t.Errorf("expected: %s, got: %v", ts, got.Ptr)
+		}
+		if !got.NullTime.Valid || !got.NullTime.Time.Equal(ts) {
+			t.Errorf("expected: %s, got: %v", ts, got.NullTime)
+		}
+
+		var upload TestTimestamps
+		if err := Unmarshal([]byte("cpu errors=1i 1712791403123456789"), &upload); err != nil {
+			t.Fatal(err)
+		}
+		if upload.Upload != nil || upload.Ptr == nil || !upload.Ptr.Equal(ts) {
+			t.Errorf("expected skipped timestamp of Timestamper, got: %+v", upload)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		samples := []any{
+			struct {
+				Name string `influx:",measurement"`
+				Ts   string `influx:",timestamp"`
+			}{},
+			struct {
+				Name string `influx:",measurement"`
+				Ts   int64  `influx:",timestamp,unit=min"`
+			}{},
+			struct {
+				Name string `influx:",measurement"`
+				Ts   any    `influx:",timestamp"`
+			}{Name: "cpu", Ts: 1.5},
+		}
+		for _, sample := range samples {
+			if _, err := Marshal(sample); !errors.Is(err, ErrUnsupportedType) {
+				t.Errorf("expected ErrUnsupportedType for %T, got: %v", sample, err)
+			}
+		}
+	})
+}
+
+// celsius implements Marshaler with pointer receiver.
+type celsius float64
+
+func (c *celsius) MarshalInflux() (string, error) {
+	return strconv.FormatFloat(float64(*c), 'f', 1, 64), nil
+}
+
+type TestPointerMethods struct {
+	Temp    celsius            `influx:"temp,field"`
+	Sensors map[string]celsius `influx:"sensor_,fields"`
+	started int64
+}
+
+func (t *TestPointerMethods) InfluxMeasurement() string { return "weather" }
+
+func (t *TestPointerMethods) InfluxTimestamp() time.Time { return time.Unix(t.started, 0) }
+
+// badMarshaler has MarshalInflux method of wrong signature.
+type badMarshaler int
+
+func (badMarshaler) MarshalInflux() string { return "" }
+
+// badMeasurement has InfluxMeasurement method of wrong signature.
+type badMeasurement struct {
+	Errors int `influx:"errors,field"`
+}
+
+func (*badMeasurement) InfluxMeasurement() (string, error) { return "", nil }
+
+var (
+	_ Marshaler     = Duration{}
+	_ Marshaler     = (*celsius)(nil)
+	_ Measurementer = (*TestPointerMethods)(nil)
+	_ Timestamper   = (*TestPointerMethods)(nil)
+)
+
+func TestMethods(t *testing.T) {
+	t.Parallel()
+
+	t.Run("pointer", func(t *testing.T) {
+		v := TestPointerMethods{Temp: 21.56, Sensors: map[string]celsius{"in": 19}, started: 1712791403}
+		expected := "weather temp=21.6,sensor_in=19.0 1712791403000000000"
+		for _, sample := range []any{v, &v, []TestPointerMethods{v}} {
+			row, err := Marshal(sample)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected != string(row) {
+				t.Errorf("expected: %s, got: %s", expected, row)
+			}
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		testCases := []struct {
+			Sample   any
+			Expected string
+		}{
+			{
+				Sample: struct {
+					Name  string       `influx:",measurement"`
+					Value badMarshaler `influx:"value,field"`
+				}{},
+				Expected: "struct { Name string \"influx:\\\",measurement\\\"\"; Value influx.badMarshaler \"influx:\\\"value,field\\\"\" }.Value: " +
+					"wrong method signature: influx.badMarshaler.MarshalInflux is func() string, func() (string, error) expected",
+			},
+			{
+				Sample: badMeasurement{Errors: 1},
+				Expected: "wrong method signature: influx.badMeasurement.InfluxMeasurement is " +
+					"func() (string, error), func() string expected",
+			},
+		}
+
+		for _, testCase := range testCases {
+			_, err := Marshal(testCase.Sample)
+			if !errors.Is(err, ErrMethodSignature) {
+				t.Errorf("expected ErrMethodSignature, got: %v", err)
+			}
+			if err != nil && testCase.Expected != err.Error() {
+				t.Errorf("expected: %s, got: %s", testCase.Expected, err)
+			}
+		}
+
+		v := struct {
+			Name  string    `influx:",measurement"`
+			Value any       `influx:"value,field"`
+			Ts    time.Time `influx:",timestamp"`
+		}{Name: "cpu", Value: badMarshaler(1), Ts: time.Now()}
+		if _, err := Marshal(v); !errors.Is(err, ErrMethodSignature) {
+			t.Errorf("expected ErrMethodSignature, got: %v", err)
+		}
+	})
+}
+
+type TestHooks struct {
+	Name   string    `influx:",measurement"`
+	Host   string    `influx:"host,tag"`
+	Total  int       `influx:"total,field"`
+	Failed int       `influx:"failed,field"`
+	Ts     time.Time `influx:",timestamp"`
+}
+
+func (h TestHooks) InfluxTags() map[string]string {
+	return map[string]string{"env": "prod", "az": ""}
+}
+
+func (h *TestHooks) InfluxFields() map[string]any {
+	if h.Total == 0 {
+		return nil
+	}
+	return map[string]any{"ratio": float64(h.Failed) / float64(h.Total), "ok": h.Failed == 0}
+}
+
+// rawLine implements LineMarshaler.
+type rawLine struct {
+	Name string `influx:",measurement"`
+	row  string
+	err  error
+}
+
+func (r rawLine) MarshalInfluxLine() ([]byte, error) { return []byte(r.row), r.err }
+
+func TestLineMethods(t *testing.T) {
+	t.Parallel()
+
+	ts := time.Now()
+	suffix := " " + strconv.FormatInt(ts.UnixNano(), 10)
+
+	t.Run("hooks", func(t *testing.T) {
+		v := TestHooks{Name: "jobs", Host: "web-1", Total: 4, Failed: 1, Ts: ts}
+		_, err := Marshal(v)
+		if expected := `influx.TestHooks.InfluxTags(): empty value of tag "az"`; err == nil || expected != err.Error() {
+			t.Errorf("expected: %s, got: %v", expected, err)
+		}
+
+		expected := "jobs,host=web-1,env=prod total=4i,failed=1i,ok=false,ratio=0.25" + suffix
+		if row := ConvertToInfluxLineProtocol(v); expected != row {
+			t.Errorf("expected: %s, got: %s", expected, row)
+		}
+
+		var buf strings.Builder
+		enc := NewEncoder(&buf)
+		enc.SetValidation(ValidationDrop, "")
+		if err := enc.Encode(TestHooks{Name: "jobs", Host: "web-1", Ts: ts}); err != nil {
+			t.Fatal(err)
+		}
+		if err := enc.Encode(&v); err != nil {
+			t.Fatal(err)
+		}
+		expected = "jobs,env=prod,host=web-1 total=0i,failed=0i" + suffix + "\n" +
+			"jobs,env=prod,host=web-1 total=4i,failed=1i,ok=false,ratio=0.25" + suffix + "\n"
+		if expected != buf.String() {
+			t.Errorf("expected: %s, got: %s", expected, buf.String())
+		}
+	})
+
+	t.Run("line", func(t *testing.T) {
+		row, err := Marshal([]rawLine{{row: "cpu value=1i\n"}, {row: "mem value=2i"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := "cpu value=1i\nmem value=2i"; expected != string(row) {
+			t.Errorf("expected: %s, got: %s", expected, row)
+		}
+
+		row, err = Marshal(rawLine{row: "log message=\"a\nb\""})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := "log message=\"a\nb\""; expected != string(row) {
+			t.Errorf("expected: %s, got: %s", expected, row)
+		}
+
+		var buf strings.Builder
+		enc := NewEncoder(&buf)
+		enc.SetBatchSize(10)
+		err = enc.Encode([]rawLine{{row: "a f=1"}, {row: ""}, {row: "b f=2\n"}})
+		if !errors.Is(err, ErrNoFields) {
+			t.Errorf("expected ErrNoFields, got: %v", err)
+		}
+		err = enc.Encode(rawLine{row: "a f=1\nb f=2"})
+		if expected := "influx.rawLine.MarshalInfluxLine(): newline is not allowed outside of string values"; !errors.Is(err, ErrNewline) ||
+			expected != err.Error() {
+			t.Errorf("expected: %s, got: %v", expected, err)
+		}
+		if err := enc.Flush(); err != nil || buf.Len() != 0 {
+			t.Errorf("expected nothing written, got: %q (%v)", buf.String(), err)
+		}
+
+		_, err = Marshal(rawLine{err: errors.New("no data")})
+		if !errors.Is(err, ErrMarshalInflux) {
+			t.Errorf("expected ErrMarshalInflux, got: %v", err)
+		}
+		if expected := "influx.rawLine.MarshalInfluxLine(): MarshalInflux error: no data"; err == nil || expected != err.Error() {
+			t.Errorf("expected: %s, got: %v", expected, err)
+		}
+	})
+}
+
+func TestFloats(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		Sample     any
+		Expected   string
+		FloatPoint string
+	}{
+		{Sample: 1.0, Expected: "1", FloatPoint: "1.0"},
+		{Sample: -0.0, Expected: "0", FloatPoint: "0.0"},
+		{Sample: 0.1, Expected: "0.1", FloatPoint: "0.1"},
+		{Sample: float32(0.1), Expected: "0.1", FloatPoint: "0.1"},
+		{Sample: float32(16777216), Expected: "16777216", FloatPoint: "16777216.0"},
+		{Sample: 2123423424.34345531, Expected: "2123423424.3434553", FloatPoint: "2123423424.3434553"},
+		{Sample: 1e20, Expected: "100000000000000000000", FloatPoint: "100000000000000000000.0"},
+		{Sample: 1e21, Expected: "1e+21", FloatPoint: "1e+21"},
+		{Sample: 0.000001, Expected: "0.000001", FloatPoint: "0.000001"},
+		{Sample: 1.5e-9, Expected: "1.5e-9", FloatPoint: "1.5e-9"},
+		{Sample: -1.5e-300, Expected: "-1.5e-300", FloatPoint: "-1.5e-300"},
+		{Sample: math.MaxFloat64, Expected: "1.7976931348623157e+308", FloatPoint: "1.7976931348623157e+308"},
+		{Sample: FixedFloat(3, 0), Expected: "3", FloatPoint: "3.0"},
+		{Sample: true, Expected: "true", FloatPoint: "true"},
+	}
+
+	ts := time.Now()
+	suffix := " " + strconv.FormatInt(ts.UnixNano(), 10)
+	for _, testCase := range testCases {
+		v := struct {
+			Name  string    `influx:",measurement"`
+			Value any       `influx:"value,field"`
+			Ts    time.Time `influx:",timestamp"`
+		}{Name: "cpu", Value: testCase.Sample, Ts: ts}
+
+		row, err := Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := "cpu value=" + testCase.Expected + suffix; expected != string(row) {
+			t.Errorf("expected: %s, got: %s", expected, row)
+		}
+
+		var buf strings.Builder
+		enc := NewEncoder(&buf)
+		enc.SetFloatPoint(true)
+		if err := enc.Encode(v); err != nil {
+			t.Fatal(err)
+		}
+		if expected := "cpu value=" + testCase.FloatPoint + suffix + "\n"; expected != buf.String() {
+			t.Errorf("expected: %s, got: %s", expected, buf.String())
+		}
+	}
+
+	t.Run("non-finite", func(t *testing.T) {
+		v := struct {
+			Name  string    `influx:",measurement"`
+			Load  float64   `influx:"load,field"`
+			Rate  float32   `influx:"rate,field"`
+			Value Value     `influx:"value,field"`
+			Ts    time.Time `influx:",timestamp"`
+		}{Name: "cpu", Load: math.NaN(), Rate: float32(math.Inf(-1)), Value: Float(math.Inf(1)), Ts: ts}
+
+		_, err := Marshal(v)
+		if !errors.Is(err, ErrNonFiniteFloat) {
+			t.Errorf("expected ErrNonFiniteFloat, got: %v", err)
+		}
+		if expected := `struct { Name string "influx:\",measurement\""; Load float64 "influx:\"load,field\""; ` +
+			`Rate float32 "influx:\"rate,field\""; Value influx.Value "influx:\"value,field\""; ` +
+			`Ts time.Time "influx:\",timestamp\"" }.Load: NaN or Inf float of field "load"`; err == nil || expected != err.Error() {
+			t.Errorf("expected: %s, got: %v", expected, err)
+		}
+
+		v.Load = 0.5
+		if expected := "cpu load=0.5" + suffix; expected != ConvertToInfluxLineProtocol(v) {
+			t.Errorf("expected: %s, got: %s", expected, ConvertToInfluxLineProtocol(v))
+		}
+	})
+}
+
+type TestDurations struct {
+	Name    string                   `influx:",measurement"`
+	Total   time.Duration            `influx:"total,field"`
+	Backup  time.Duration            `influx:"backup,field,unit=min,precision=2"`
+	Upload  time.Duration            `influx:"upload,field,unit=s"`
+	Latency time.Duration            `influx:"latency,field,unit=ms"`
+	Phases  map[string]time.Duration `influx:"phase_,fields,unit=ms,precision=1"`
+	Ts      time.Time                `influx:",timestamp"`
+}
+
+func TestDurationFields(t *testing.T) {
+	t.Parallel()
+
+	ts := time.Now()
+	v := TestDurations{
+		Name:    "backup",
+		Total:   45*time.Minute + 30230*time.Millisecond,
+		Backup:  45*time.Minute + 30230*time.Millisecond,
+		Upload:  90*time.Second + 250*time.Millisecond,
+		Latency: 1500 * time.Microsecond,
+		Phases:  map[string]time.Duration{"dump": 1260 * time.Microsecond},
+		Ts:      ts,
+	}
+
+	row, err := Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "backup total=2730230000000i,backup=45.50,upload=90.25,latency=1i,phase_dump=1.3 " +
+		strconv.FormatInt(ts.UnixNano(), 10)
+	if expected != string(row) {
+		t.Errorf("expected: %s, got: %s", expected, row)
+	}
+
+	var got TestDurations
+	if err := Unmarshal(row, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Total != v.Total || got.Backup != 45*time.Minute+30*time.Second || got.Upload != v.Upload ||
+		got.Latency != time.Millisecond || got.Phases["dump"] != 1300*time.Microsecond {
+		t.Errorf("unexpected durations: %+v", got)
+	}
+
+	t.Run("error", func(t *testing.T) {
+		samples := []any{
+			struct {
+				Name string        `influx:",measurement"`
+				Time time.Duration `influx:"time,field,unit=days"`
+			}{},
+			struct {
+				Name string        `influx:",measurement"`
+				Time time.Duration `influx:"time,field,precision=-1"`
+			}{},
+			struct {
+				Name string `influx:",measurement"`
+				Time int64  `influx:"time,field,unit=s"`
+			}{},
+		}
+		for _, sample := range samples {
+			if _, err := Marshal(sample); !errors.Is(err, ErrUnsupportedType) {
+				t.Errorf("expected ErrUnsupportedType for %T, got: %v", sample, err)
+			}
+		}
+	})
+}
+
+func TestFieldKinds(t *testing.T) {
+	t.Parallel()
+
+	var n int
+	samples := []struct {
+		Value     any
+		Supported bool
+	}{
+		{Value: true, Supported: true},
+		{Value: int(-1), Supported: true},
+		{Value: int8(-1), Supported: true},
+		{Value: int16(-1), Supported: true},
+		{Value: int32(-1), Supported: true},
+		{Value: int64(-1), Supported: true},
+		{Value: uint(1), Supported: true},
+		{Value: uint8(1), Supported: true},
+		{Value: uint16(1), Supported: true},
+		{Value: uint32(1), Supported: true},
+		{Value: uint64(1), Supported: true},
+		{Value: uintptr(1)},
+		{Value: float32(0.5), Supported: true},
+		{Value: float64(0.5), Supported: true},
+		{Value: complex64(1 + 2i)},
+		{Value: complex128(1 + 2i)},
+		{Value: [2]int{1, 2}},
+		{Value: make(chan int)},
+		{Value: func() {}},
+		{Value: map[string]int{"a": 1}},
+		{Value: &n, Supported: true},
+		{Value: []int{1, 2}},
+		{Value: "a", Supported: true},
+		{Value: struct{ A int }{A: 1}},
+		{Value: unsafe.Pointer(&n)},
+	}
+
+	kinds := map[reflect.Kind]bool{reflect.Interface: true} // the field of any type is tested below
+	for _, sample := range samples {
+		kinds[reflect.TypeOf(sample.Value).Kind()] = true
+	}
+	for k := reflect.Bool; k <= reflect.UnsafePointer; k++ {
+		if !kinds[k] {
+			t.Errorf("no sample of %s", k)
+		}
+	}
+
+	for _, sample := range samples {
+		typ := reflect.TypeOf(sample.Value)
+		for _, kind := range []string{"tag", "field"} {
+			for _, static := range []bool{true, false} {
+				ft := typ
+				if !static {
+					ft = reflect.TypeFor[any]()
+				}
+				st := reflect.StructOf([]reflect.StructField{
+					{Name: "Name", Type: reflect.TypeFor[string](), Tag: `influx:",measurement"`},
+					{Name: "Value", Type: ft, Tag: reflect.StructTag(`influx:"value,` + kind + `"`)},
+					{Name: "Count", Type: reflect.TypeFor[int](), Tag: `influx:"count,field"`},
+					{Name: "Ts", Type: reflect.TypeFor[time.Time](), Tag: `influx:",timestamp"`},
+				})
+				v := reflect.New(st).Elem()
+				v.Field(0).SetString("node")
+				v.Field(1).Set(reflect.ValueOf(sample.Value))
+				v.Field(2).SetInt(1)
+				v.Field(3).Set(reflect.ValueOf(time.Now()))
+
+				_, err := Marshal(v.Interface())
+				switch {
+				case sample.Supported && err != nil:
+					t.Errorf("%s of %s (static %t): unexpected error: %v", kind, typ, static, err)
+				case !sample.Supported && !errors.Is(err, ErrUnsupportedType):
+					t.Errorf("%s of %s (static %t): expected ErrUnsupportedType, got: %v", kind, typ, static, err)
+				case !sample.Supported && !strings.Contains(err.Error(), ".Value"):
+					t.Errorf("%s of %s (static %t): expected field name in error, got: %v", kind, typ, static, err)
+				case !sample.Supported:
+					var fieldErr *FieldError
+					if !errors.As(err, &fieldErr) || fieldErr.Field != "Value" {
+						t.Errorf("%s of %s: expected FieldError of Value, got: %v", kind, typ, err)
+					}
+				}
+				ConvertToInfluxLineProtocol(v.Interface()) // must not panic
+			}
+		}
+	}
+
+	t.Run("nested", func(t *testing.T) {
+		type disks struct {
+			Disks []string `influx:"disks,field"`
+		}
+		type node struct {
+			Name string `influx:",measurement"`
+			Net  disks  `influx:"net_,prefix"`
+		}
+		_, err := Marshal(node{Name: "node"})
+		var fieldErr *FieldError
+		if !errors.As(err, &fieldErr) || fieldErr.Struct != "influx.node" || fieldErr.Field != "Net.Disks" {
+			t.Errorf("expected FieldError of influx.node.Net.Disks, got: %v", err)
+		}
+		if expected := "influx.node.Net.Disks: unsupported type: field of []string"; err == nil || expected != err.Error() {
+			t.Errorf("expected: %s, got: %v", expected, err)
+		}
+	})
+}
+
+type TestUnexported struct {
+	Name    string            `influx:",measurement"`
+	Errors  int               `influx:"errors,field"`
+	host    string            `influx:"host,tag"`
+	retries int               `influx:"retries,field"`
+	disks   []string          `influx:"disks,field"`
+	special SpecialString     `influx:"special,field"`
+	labels  map[string]string `influx:",tags"`
+	ts      time.Time         `influx:",timestamp"`
+}
+
+func TestUnexportedFields(t *testing.T) {
+	t.Parallel()
+
+	v := TestUnexported{
+		Name: "node", Errors: 1, host: "web-1", retries: 2, disks: []string{"sda"},
+		special: "x", labels: map[string]string{"dc": "east-1"}, ts: time.Now(),
+	}
+
+	var buf strings.Builder
+	enc := NewEncoder(&buf)
+	enc.SetOptionalTimestamp(true)
+	if err := enc.Encode(v); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "node errors=1i\n"; expected != buf.String() {
+		t.Errorf("expected: %s, got: %s", expected, buf.String())
+	}
+
+	var got TestUnexported
+	if err := Unmarshal([]byte("node,host=web-1 errors=1i,retries=2i"), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Errors != 1 || got.host != "" || got.retries != 0 {
+		t.Errorf("unexpected value: %+v", got)
+	}
+
+	err := Validate(v)
+	if !errors.Is(err, ErrUnexportedField) {
+		t.Errorf("expected ErrUnexportedField, got: %v", err)
+	}
+	for _, field := range []string{"host", "retries", "disks", "special", "labels", "ts"} {
+		if err == nil || !strings.Contains(err.Error(), "influx.TestUnexported."+field+": ") {
+			t.Errorf("expected error of %s, got: %v", field, err)
+		}
+	}
+}
+
+func BenchmarkAppendLine(b *testing.B) {
+	v := benchPoint{
+		Operation: "backup", DataCenter: "east-1", CloudProvider: "AWS",
+		Errors: 2, Processed: 100, Rate: 0.5, Timestamp: time.Now(),
+	}
+	buf := make([]byte, 0, 256)
+	b.ReportAllocs()
+	for range b.N {
+		var err error
+		if buf, err = AppendLine(buf[:0], &v); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// TestAppendLine is not parallel, testing.AllocsPerRun counts the allocations
+// of all goroutines.
+func TestAppendLine(t *testing.T) {
+	ts := time.Now()
+	v := benchPoint{
+		Operation: "backup", DataCenter: "east-1", CloudProvider: "AWS",
+		Errors: 2, Processed: 100, Rate: 0.5, Timestamp: ts,
+	}
+	expected := "# backup\nbackup,cloud=AWS,datacenter=east-1 errors=2i,processed=100u,rate=0.5 " +
+		strconv.FormatInt(ts.UnixNano(), 10)
+
+	buf, err := AppendLine([]byte("# backup\n"), v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected != string(buf) {
+		t.Errorf("expected: %s, got: %s", expected, buf)
+	}
+
+	buf, err = AppendLine(buf, struct{}{})
+	if !errors.Is(err, ErrMissingMeasurement) {
+		t.Errorf("expected ErrMissingMeasurement, got: %v", err)
+	}
+	if expected != string(buf) {
+		t.Errorf("expected unchanged buffer: %s, got: %s", expected, buf)
+	}
+
+	if raceEnabled {
+		return
+	}
+	buf = make([]byte, 0, 256)
+	allocs := testing.AllocsPerRun(100, func() {
+		if _, err := AppendLine(buf, &v); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("expected 0 allocations, got: %.1f", allocs)
+	}
+}
+
+type testLevel string
+
+func (l testLevel) String() string { return "level: " + string(l) }
+
+type testState int
+
+func (s testState) String() string { return "state " + strconv.Itoa(int(s)) }
+
+type testVersion struct{ Major, Minor int }
+
+func (v testVersion) String() string { return fmt.Sprintf("v%d.%d beta", v.Major, v.Minor) }
+
+func TestStringers(t *testing.T) {
+	t.Parallel()
+
+	v := struct {
+		Name    string      `influx:",measurement"`
+		Version testVersion `influx:"version,tag"`
+		Level   testLevel   `influx:"lt,tag"`
+		Field   testLevel   `influx:"level,field"`
+		State   testState   `influx:"state,field"`
+		Release testVersion `influx:"release,field"`
+		At      time.Time   `influx:"at,field"`
+		Addr    net.IP      `influx:"addr,field"`
+		Ts      time.Time   `influx:",timestamp"`
+	}{
+		Name: "m", Version: testVersion{1, 2}, Level: "a b", Field: "warn now", State: 3,
+		Release: testVersion{2, 0}, At: time.Unix(1, 0).UTC(), Addr: net.IPv4(10, 0, 0, 1), Ts: time.Unix(1, 0),
+	}
+	row, err := Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `m,lt=a\ b,version=v1.2\ beta level="warn now",state=3i,release="v2.0 beta",` +
+		`at="1970-01-01 00:00:01 +0000 UTC",addr="10.0.0.1" 1000000000`
+	if expected != string(row) {
+		t.Errorf("expected: %s, got: %s", expected, row)
+	}
+
+	t.Run("dynamic", func(t *testing.T) {
+		v := struct {
+			Name  string                  `influx:",measurement"`
+			Tags  map[string]any          `influx:",tags"`
+			Value map[string]fmt.Stringer `influx:",fields"`
+			Ts    time.Time               `influx:",timestamp"`
+		}{
+			Name: "m", Tags: map[string]any{"version": testVersion{1, 2}},
+			Value: map[string]fmt.Stringer{"release": testVersion{2, 0}}, Ts: time.Unix(1, 0),
+		}
+		row, err := Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := `m,version=v1.2\ beta release="v2.0 beta" 1000000000`; expected != string(row) {
+			t.Errorf("expected: %s, got: %s", expected, row)
+		}
+	})
+}
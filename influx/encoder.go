@@ -0,0 +1,164 @@
+package influx
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"reflect"
+	"time"
+)
+
+// An Encoder writes influxdb line protocol rows to an output stream.
+//
+// The rows are encoded to the internal buffer which is reused between calls,
+// every row is terminated by newline. By default every row is written to the
+// output stream by the Encode call, use SetBatchSize and SetFlushInterval
+// to write them in batches, in this case Flush must be called at the end.
+//
+// The Encoder is not safe for concurrent use.
+type Encoder struct {
+	w    io.Writer
+	buf  []byte
+	opts encOpts
+
+	batchSize     int
+	flushInterval time.Duration
+	pending       int // number of rows in buffer
+	lastFlush     time.Time
+}
+
+// ErrorPolicy is the policy of handling the errors of encoding, see Encoder.SetErrorPolicy.
+type ErrorPolicy uint8
+
+const (
+	// ErrorAbort fails the Encode call, none of the rows of its value is written.
+	ErrorAbort ErrorPolicy = iota
+	// ErrorSkipField omits the tags and fields which values can't be encoded: the
+	// MarshalInflux or MarshalInfluxValue method fails, the value can't be coerced
+	// to the type set by struct tag or the dynamic value is of unsupported type.
+	// The rest errors of point, e.g. ErrNoFields, fail the Encode call.
+	ErrorSkipField
+	// ErrorSkipPoint omits the points failed with any error, the rest points of
+	// slice, array or channel are written.
+	ErrorSkipPoint
+)
+
+// NewEncoder returns a new encoder that writes to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w, opts: encOpts{sortTags: true}, lastFlush: time.Now()}
+}
+
+// SetBatchSize makes the encoder to buffer up to n rows before writing them
+// to the output stream, n <= 1 means writing every row at once.
+func (enc *Encoder) SetBatchSize(n int) { enc.batchSize = n }
+
+// SetFlushInterval makes the encoder to write the buffered rows if d passed since
+// the last write, even if the batch is not full. The interval is checked on
+// every Encode call, zero d disables it.
+func (enc *Encoder) SetFlushInterval(d time.Duration) { enc.flushInterval = d }
+
+// SetValidation sets the policy of handling the tags and fields which are not allowed
+// by line protocol, the placeholder is used to replace the invalid keys and values
+// with ValidationReplace policy. The default policy is ValidationError.
+func (enc *Encoder) SetValidation(policy ValidationPolicy, placeholder string) {
+	enc.opts.validation = policy
+	enc.opts.placeholder = placeholder
+}
+
+// SetSortTags sets whether the tags are sorted by keys, which is recommended by
+// InfluxDB for write performance. The tags are sorted by default.
+func (enc *Encoder) SetSortTags(on bool) { enc.opts.sortTags = on }
+
+// SetPrecision sets the precision of timestamps: time.Second, time.Millisecond,
+// time.Microsecond or time.Nanosecond (the default), the timestamps are truncated
+// accordingly. It must match the precision of InfluxDB write endpoint. The precision
+// set by the option of struct tag, e.g. `influx:",timestamp,ms"`, takes precedence.
+// The other precisions make Encode to fail with ErrInvalidPrecision.
+func (enc *Encoder) SetPrecision(d time.Duration) { enc.opts.precision = d }
+
+// SetOptionalTimestamp makes the points without timestamp (the timestamp is zero
+// or not tagged at all) to be written without it instead of failing with
+// ErrMissingTimestamp, the server assigns its own time to such points.
+func (enc *Encoder) SetOptionalTimestamp(on bool) { enc.opts.optionalTimestamp = on }
+
+// SetClock sets the function returning the timestamp of points which have none,
+// e.g. time.Now for metrics written live. The nil now restores the default behaviour.
+func (enc *Encoder) SetClock(now func() time.Time) { enc.opts.now = now }
+
+// SetFloatPoint sets whether the integer floats are written with decimal point,
+// e.g. 1.0 instead of 1, so the type of field is clear from the row.
+func (enc *Encoder) SetFloatPoint(on bool) { enc.opts.floatPoint = on }
+
+// SetErrorPolicy sets the policy of handling the errors of tags and fields, e.g.
+// failed MarshalInflux methods, and the errors of points. The default policy
+// is ErrorAbort. Use SetErrorHandler or SetErrorLogger to be aware of skipped errors.
+func (enc *Encoder) SetErrorPolicy(policy ErrorPolicy) { enc.opts.errorPolicy = policy }
+
+// SetErrorHandler sets the function called with the errors skipped by error policy,
+// the nil handler makes them to be skipped silently.
+func (enc *Encoder) SetErrorHandler(handler func(error)) { enc.opts.onError = handler }
+
+// SetErrorLogger makes the errors skipped by error policy to be logged by l
+// with warning level, the nil l makes them to be skipped silently.
+func (enc *Encoder) SetErrorLogger(l *slog.Logger) {
+	if l == nil {
+		enc.opts.onError = nil
+		return
+	}
+	enc.opts.onError = func(err error) {
+		l.Warn("influx: skipped", "error", err)
+	}
+}
+
+// SetStrict sets whether the mistakes of struct tags reported by Validate fail
+// the points, by default they are ignored.
+func (enc *Encoder) SetStrict(on bool) { enc.opts.strict = on }
+
+// Encode writes the line protocol row of v followed by newline to the stream,
+// see Marshal for details. The slices, arrays and channels of structs are written
+// as one row per element, none of them is written in case of error unless it is
+// skipped by error policy. The channel is read until it is closed, even if some
+// element fails. The rows are buffered if batching is enabled.
+func (enc *Encoder) Encode(v any) error {
+	switch enc.opts.precision {
+	case 0, time.Second, time.Millisecond, time.Microsecond, time.Nanosecond:
+	default:
+		return fmt.Errorf("%w: %s", ErrInvalidPrecision, enc.opts.precision)
+	}
+
+	mark := len(enc.buf)
+	buf, n, err := appendLines(enc.buf, reflect.ValueOf(v), enc.opts)
+	if err != nil {
+		enc.buf = buf[:mark]
+		return err
+	}
+	if n == 0 || len(buf) == mark {
+		return nil
+	}
+	enc.buf = append(buf, '\n')
+	enc.pending += n
+
+	if enc.pending >= enc.batchSize ||
+		enc.flushInterval > 0 && time.Since(enc.lastFlush) >= enc.flushInterval {
+		return enc.Flush()
+	}
+	return nil
+}
+
+// Flush writes the buffered rows to the output stream. In case of error the rows
+// which were not written are kept in buffer.
+func (enc *Encoder) Flush() error {
+	enc.lastFlush = time.Now()
+	if len(enc.buf) == 0 {
+		return nil
+	}
+
+	n, err := enc.w.Write(enc.buf)
+	if err != nil {
+		enc.buf = enc.buf[:copy(enc.buf, enc.buf[n:])]
+		return err
+	}
+	enc.buf = enc.buf[:0]
+	enc.pending = 0
+	return nil
+}
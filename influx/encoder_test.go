@@ -0,0 +1,504 @@
+package influx
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// countingWriter counts the Write calls.
+type countingWriter struct {
+	bytes.Buffer
+	writes int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return w.Buffer.Write(p)
+}
+
+// failingWriter writes n bytes and fails.
+type failingWriter struct {
+	bytes.Buffer
+	n int
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		w.Buffer.Write(p[:w.n])
+		n := w.n
+		w.n = 0
+		return n, errors.New("disk is full")
+	}
+	w.n -= len(p)
+	return w.Buffer.Write(p)
+}
+
+func TestEncoder(t *testing.T) {
+	t.Parallel()
+
+	ts := time.Now()
+	point := func(errors int) benchPoint {
+		return benchPoint{
+			Operation: "backup", DataCenter: "east-1", CloudProvider: "AWS",
+			Errors: errors, Processed: 100, Rate: 0.5, Timestamp: ts,
+		}
+	}
+	row := func(errors int) string {
+		return "backup,cloud=AWS,datacenter=east-1 errors=" + strconv.Itoa(errors) +
+			"i,processed=100u,rate=0.5 " + strconv.FormatInt(ts.UnixNano(), 10) + "\n"
+	}
+
+	t.Run("default", func(t *testing.T) {
+		var w countingWriter
+		enc := NewEncoder(&w)
+		for i := range 3 {
+			if err := enc.Encode(point(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if w.writes != 3 {
+			t.Errorf("expected 3 writes, got: %d", w.writes)
+		}
+		expected := row(0) + row(1) + row(2)
+		if expected != w.String() {
+			t.Errorf("expected: %s, got: %s", expected, w.String())
+		}
+	})
+
+	t.Run("batch", func(t *testing.T) {
+		var w countingWriter
+		enc := NewEncoder(&w)
+		enc.SetBatchSize(2)
+		for i := range 5 {
+			if err := enc.Encode(point(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if w.writes != 2 {
+			t.Errorf("expected 2 writes, got: %d", w.writes)
+		}
+		if err := enc.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		if w.writes != 3 {
+			t.Errorf("expected 3 writes, got: %d", w.writes)
+		}
+		expected := row(0) + row(1) + row(2) + row(3) + row(4)
+		if expected != w.String() {
+			t.Errorf("expected: %s, got: %s", expected, w.String())
+		}
+	})
+
+	t.Run("interval", func(t *testing.T) {
+		var w countingWriter
+		enc := NewEncoder(&w)
+		enc.SetBatchSize(100)
+		enc.SetFlushInterval(time.Hour)
+		if err := enc.Encode(point(0)); err != nil {
+			t.Fatal(err)
+		}
+		if w.writes != 0 {
+			t.Errorf("expected 0 writes, got: %d", w.writes)
+		}
+
+		enc.lastFlush = time.Now().Add(-time.Hour)
+		if err := enc.Encode(point(1)); err != nil {
+			t.Fatal(err)
+		}
+		if w.writes != 1 {
+			t.Errorf("expected 1 write, got: %d", w.writes)
+		}
+		if expected := row(0) + row(1); expected != w.String() {
+			t.Errorf("expected: %s, got: %s", expected, w.String())
+		}
+	})
+
+	t.Run("batch/multiline", func(t *testing.T) {
+		var w countingWriter
+		enc := NewEncoder(&w)
+		enc.SetBatchSize(3)
+		v := struct {
+			Name    string `influx:",measurement"`
+			Message string `influx:"message,field"`
+		}{Name: "log", Message: "l1\nl2\nl3"}
+		enc.SetOptionalTimestamp(true)
+		for range 2 {
+			if err := enc.Encode(v); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := enc.Encode([]any{v, v}); err != nil {
+			t.Fatal(err)
+		}
+		if w.writes != 1 {
+			t.Errorf("expected 1 write, got: %d", w.writes)
+		}
+		if enc.pending != 0 || len(enc.buf) != 0 {
+			t.Errorf("expected empty buffer, got %d rows: %s", enc.pending, enc.buf)
+		}
+	})
+
+	t.Run("error/encode", func(t *testing.T) {
+		var w countingWriter
+		enc := NewEncoder(&w)
+		enc.SetBatchSize(2)
+		if err := enc.Encode(point(0)); err != nil {
+			t.Fatal(err)
+		}
+		if err := enc.Encode(struct{}{}); !errors.Is(err, ErrMissingMeasurement) {
+			t.Errorf("expected ErrMissingMeasurement, got: %v", err)
+		}
+		if err := enc.Encode(point(1)); err != nil {
+			t.Fatal(err)
+		}
+		if expected := row(0) + row(1); expected != w.String() {
+			t.Errorf("expected: %s, got: %s", expected, w.String())
+		}
+	})
+
+	t.Run("error/write", func(t *testing.T) {
+		w := failingWriter{n: 10}
+		enc := NewEncoder(&w)
+		if err := enc.Encode(point(0)); err == nil {
+			t.Error("expected error not found")
+		}
+		w.n = 1000
+		if err := enc.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		if expected := row(0); expected != w.String() {
+			t.Errorf("expected: %s, got: %s", expected, w.String())
+		}
+	})
+}
+
+func BenchmarkEncoder(b *testing.B) {
+	v := benchPoint{
+		Operation: "backup", DataCenter: "east-1", CloudProvider: "AWS",
+		Errors: 2, Processed: 100, Rate: 0.5, Timestamp: time.Now(),
+	}
+	enc := NewEncoder(io.Discard)
+	enc.SetBatchSize(1000)
+	b.ReportAllocs()
+	for range b.N {
+		if err := enc.Encode(v); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err := enc.Flush(); err != nil {
+		b.Fatal(err)
+	}
+}
+
+func TestEncoderLegacy(t *testing.T) {
+	t.Parallel()
+
+	var buf strings.Builder
+	enc := NewEncoder(&buf)
+	v := TestTimestamp{Name: "backup", ExecutionTime: Duration{Value: "45m", To: time.Minute}}
+	if err := enc.Encode(v); err != nil {
+		t.Fatal(err)
+	}
+	if expected := ConvertToInfluxLineProtocol(v) + "\n"; expected != buf.String() {
+		t.Errorf("expected: %s, got: %s", expected, buf.String())
+	}
+}
+
+func TestEncoderSlice(t *testing.T) {
+	t.Parallel()
+
+	ts := time.Now()
+	points := []benchPoint{
+		{Operation: "backup", Errors: 1, Timestamp: ts},
+		{Operation: "backup", Errors: 2, Timestamp: ts},
+		{Operation: "backup", Errors: 3, Timestamp: ts},
+	}
+
+	var w countingWriter
+	enc := NewEncoder(&w)
+	enc.SetBatchSize(3)
+	if err := enc.Encode(points[:2]); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Encode([]benchPoint{}); err != nil {
+		t.Fatal(err)
+	}
+	if w.writes != 0 {
+		t.Errorf("expected 0 writes, got: %d", w.writes)
+	}
+	if err := enc.Encode(points[2]); err != nil {
+		t.Fatal(err)
+	}
+	if w.writes != 1 {
+		t.Errorf("expected 1 write, got: %d", w.writes)
+	}
+
+	expected, err := Marshal(points)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(expected)+"\n" != w.String() {
+		t.Errorf("expected: %s, got: %s", expected, w.String())
+	}
+}
+
+type TestPrecision struct {
+	Name   string    `influx:",measurement"`
+	Errors int       `influx:"errors,field"`
+	Ts     time.Time `influx:",timestamp,ms"`
+}
+
+func TestEncoderPrecision(t *testing.T) {
+	t.Parallel()
+
+	ts := time.Date(2024, time.April, 10, 23, 23, 23, 123456789, time.UTC)
+	v := struct {
+		Name   string    `influx:",measurement"`
+		Errors int       `influx:"errors,field"`
+		Ts     time.Time `influx:",timestamp"`
+	}{Name: "backup", Errors: 1, Ts: ts}
+
+	testCases := []struct {
+		Precision time.Duration
+		Expected  string
+	}{
+		{Precision: time.Second, Expected: "backup errors=1i 1712791403\n"},
+		{Precision: time.Millisecond, Expected: "backup errors=1i 1712791403123\n"},
+		{Precision: time.Microsecond, Expected: "backup errors=1i 1712791403123456\n"},
+		{Precision: time.Nanosecond, Expected: "backup errors=1i 1712791403123456789\n"},
+		{Precision: 0, Expected: "backup errors=1i 1712791403123456789\n"},
+	}
+
+	for _, testCase := range testCases {
+		var buf strings.Builder
+		enc := NewEncoder(&buf)
+		enc.SetPrecision(testCase.Precision)
+		if err := enc.Encode(v); err != nil {
+			t.Fatal(err)
+		}
+		if testCase.Expected != buf.String() {
+			t.Errorf("%s: expected: %s, got: %s", testCase.Precision, testCase.Expected, buf.String())
+		}
+	}
+
+	t.Run("error", func(t *testing.T) {
+		var buf strings.Builder
+		enc := NewEncoder(&buf)
+		for _, precision := range []time.Duration{time.Minute, 10 * time.Millisecond, -time.Second} {
+			enc.SetPrecision(precision)
+			if err := enc.Encode(v); !errors.Is(err, ErrInvalidPrecision) {
+				t.Errorf("%s: expected ErrInvalidPrecision, got: %v", precision, err)
+			}
+		}
+		if buf.Len() != 0 {
+			t.Errorf("unexpected output: %s", buf.String())
+		}
+	})
+
+	t.Run("tag", func(t *testing.T) {
+		v := TestPrecision{Name: "backup", Errors: 1, Ts: ts}
+		if expected := "backup errors=1i 1712791403123"; expected != ConvertToInfluxLineProtocol(v) {
+			t.Errorf("expected: %s, got: %s", expected, ConvertToInfluxLineProtocol(v))
+		}
+
+		var buf strings.Builder
+		enc := NewEncoder(&buf)
+		enc.SetPrecision(time.Second)
+		if err := enc.Encode(v); err != nil {
+			t.Fatal(err)
+		}
+		if expected := "backup errors=1i 1712791403123\n"; expected != buf.String() {
+			t.Errorf("expected: %s, got: %s", expected, buf.String())
+		}
+
+		var got TestPrecision
+		if err := Unmarshal([]byte(buf.String()), &got); err != nil {
+			t.Fatal(err)
+		}
+		if expected := ts.Truncate(time.Millisecond); !got.Ts.Equal(expected) {
+			t.Errorf("expected: %s, got: %s", expected, got.Ts)
+		}
+	})
+}
+
+type TestOptionalTimestamp struct {
+	Name   string    `influx:",measurement"`
+	Errors int       `influx:"errors,field"`
+	Ts     time.Time `influx:",timestamp,omitempty"`
+}
+
+func TestEncoderOptionalTimestamp(t *testing.T) {
+	t.Parallel()
+
+	v := struct {
+		Name   string    `influx:",measurement"`
+		Errors int       `influx:"errors,field"`
+		Ts     time.Time `influx:",timestamp"`
+	}{Name: "backup", Errors: 1}
+
+	t.Run("error", func(t *testing.T) {
+		enc := NewEncoder(&strings.Builder{})
+		if err := enc.Encode(v); !errors.Is(err, ErrMissingTimestamp) {
+			t.Errorf("expected ErrMissingTimestamp, got: %v", err)
+		}
+	})
+
+	t.Run("omit", func(t *testing.T) {
+		var buf strings.Builder
+		enc := NewEncoder(&buf)
+		enc.SetOptionalTimestamp(true)
+		if err := enc.Encode(v); err != nil {
+			t.Fatal(err)
+		}
+		if err := enc.Encode(struct {
+			Name   string `influx:",measurement"`
+			Errors int    `influx:"errors,field"`
+		}{Name: "restore", Errors: 2}); err != nil {
+			t.Fatal(err)
+		}
+		if expected := "backup errors=1i\nrestore errors=2i\n"; expected != buf.String() {
+			t.Errorf("expected: %s, got: %s", expected, buf.String())
+		}
+	})
+
+	t.Run("clock", func(t *testing.T) {
+		var buf strings.Builder
+		enc := NewEncoder(&buf)
+		enc.SetOptionalTimestamp(true)
+		enc.SetClock(func() time.Time { return time.Unix(1712791403, 0) })
+		enc.SetPrecision(time.Second)
+		if err := enc.Encode(v); err != nil {
+			t.Fatal(err)
+		}
+
+		v := v
+		v.Ts = time.Unix(1712791400, 0)
+		if err := enc.Encode(v); err != nil {
+			t.Fatal(err)
+		}
+		if expected := "backup errors=1i 1712791403\nbackup errors=1i 1712791400\n"; expected != buf.String() {
+			t.Errorf("expected: %s, got: %s", expected, buf.String())
+		}
+	})
+
+	t.Run("tag", func(t *testing.T) {
+		v := TestOptionalTimestamp{Name: "backup", Errors: 1}
+		if expected := "backup errors=1i"; expected != ConvertToInfluxLineProtocol(v) {
+			t.Errorf("expected: %s, got: %s", expected, ConvertToInfluxLineProtocol(v))
+		}
+
+		var got TestOptionalTimestamp
+		if err := Unmarshal([]byte("backup errors=1i"), &got); err != nil {
+			t.Fatal(err)
+		}
+		if got != v {
+			t.Errorf("expected: %+v, got: %+v", v, got)
+		}
+	})
+}
+
+func TestEncoderErrorPolicy(t *testing.T) {
+	t.Parallel()
+
+	ts := time.Now()
+	points := []TestMarshal{
+		{Name: "starship", Timestamp: ts, Weight: 5000, Sensor: "onboard=45.16"},
+		{Name: "starship", Timestamp: ts, Weight: 6000, Sensor: "onboard,45.16"},
+	}
+	suffix := " " + strconv.FormatInt(ts.UnixNano(), 10) + "\n"
+
+	testCases := []struct {
+		Policy   ErrorPolicy
+		Expected string
+		Error    string
+	}{
+		{
+			Policy:   ErrorSkipField,
+			Expected: "starship weight=5000i" + suffix + "starship weight=6000i,temperature=45.16" + suffix,
+			Error:    `influx.TestMarshal.Sensor: MarshalInflux error: field "temperature": wrong format`,
+		},
+		{
+			Policy:   ErrorSkipPoint,
+			Expected: "starship weight=6000i,temperature=45.16" + suffix,
+			Error:    `element 0: influx.TestMarshal.Sensor: MarshalInflux error: field "temperature": wrong format`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		var buf strings.Builder
+		var skipped []error
+		enc := NewEncoder(&buf)
+		enc.SetErrorPolicy(testCase.Policy)
+		enc.SetErrorHandler(func(err error) { skipped = append(skipped, err) })
+		if err := enc.Encode(points); err != nil {
+			t.Fatal(err)
+		}
+		if testCase.Expected != buf.String() {
+			t.Errorf("%d: expected: %s, got: %s", testCase.Policy, testCase.Expected, buf.String())
+		}
+		if len(skipped) != 1 || !errors.Is(skipped[0], ErrMarshalInflux) || testCase.Error != skipped[0].Error() {
+			t.Errorf("%d: expected: %s, got: %v", testCase.Policy, testCase.Error, skipped)
+		}
+	}
+
+	t.Run("abort", func(t *testing.T) {
+		var buf strings.Builder
+		enc := NewEncoder(&buf)
+		if err := enc.Encode(points); !errors.Is(err, ErrMarshalInflux) {
+			t.Errorf("expected ErrMarshalInflux, got: %v", err)
+		}
+		if buf.Len() != 0 {
+			t.Errorf("unexpected output: %s", buf.String())
+		}
+	})
+
+	t.Run("point", func(t *testing.T) {
+		var buf strings.Builder
+		enc := NewEncoder(&buf)
+		enc.SetErrorPolicy(ErrorSkipPoint)
+		if err := enc.Encode(struct{}{}); err != nil {
+			t.Fatal(err)
+		}
+		if err := enc.Encode(points[1]); err != nil {
+			t.Fatal(err)
+		}
+		if expected := "starship weight=6000i,temperature=45.16" + suffix; expected != buf.String() {
+			t.Errorf("expected: %s, got: %s", expected, buf.String())
+		}
+
+		enc.SetErrorPolicy(ErrorSkipField)
+		v := struct {
+			Name   string        `influx:",measurement"`
+			Sensor SpecialString `influx:"temperature,field"`
+			Ts     time.Time     `influx:",timestamp"`
+		}{Name: "starship", Sensor: "onboard=45.16", Ts: ts}
+		if err := enc.Encode(v); !errors.Is(err, ErrNoFields) {
+			t.Errorf("expected ErrNoFields, got: %v", err)
+		}
+	})
+
+	t.Run("logger", func(t *testing.T) {
+		var buf strings.Builder
+		enc := NewEncoder(io.Discard)
+		enc.SetErrorPolicy(ErrorSkipField)
+		enc.SetErrorLogger(slog.New(slog.NewTextHandler(&buf, nil)))
+		if err := enc.Encode(points[0]); err != nil {
+			t.Fatal(err)
+		}
+		expected := `level=WARN msg="influx: skipped" error="influx.TestMarshal.Sensor: MarshalInflux error: field \"temperature\": wrong format"`
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("expected: %s, got: %s", expected, buf.String())
+		}
+
+		enc.SetErrorLogger(nil)
+		if err := enc.Encode(points[0]); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
@@ -1,51 +1,224 @@
 package influx
 
 import (
-	"fmt"
-	"log"
+	"errors"
 	"reflect"
+	"slices"
 	"strings"
 	"time"
 )
 
-func extractTagKeyVal(s string) (key string, val string) {
-	idx := strings.LastIndex(s, ",")
-	if idx == -1 {
+// structTag is the parsed `influx:"name,kind,option1,option2"` struct tag.
+type structTag struct {
+	name string
+	kind string
+	opts []string
+}
+
+// tagOptions are the known options of struct tag, the options in form of key=value
+// are recognized by the equal sign.
+var tagOptions = []string{"omitempty", "s", "ms", "us", "ns", "int", "uint", "float", "string"}
+
+// precisions are the timestamp precisions which may be set by struct tag option.
+var precisions = map[string]time.Duration{
+	"s":  time.Second,
+	"ms": time.Millisecond,
+	"us": time.Microsecond,
+	"ns": time.Nanosecond,
+}
+
+// durationUnits are the units of time.Duration values which may be set by unit option.
+var durationUnits = map[string]time.Duration{
+	"ns":  time.Nanosecond,
+	"us":  time.Microsecond,
+	"ms":  time.Millisecond,
+	"s":   time.Second,
+	"min": time.Minute,
+	"h":   time.Hour,
+}
+
+func isTagOption(s string) bool {
+	return slices.Contains(tagOptions, s) || strings.Contains(s, "=")
+}
+
+// tagKinds are the kinds of data which may be set by struct tag.
+var tagKinds = []string{"tag", "field", "measurement", "timestamp", "tags", "fields", "prefix"}
+
+// parseTag parses the struct tag. The last token which is a known kind is the kind,
+// the tokens before it are the name, so the name may contain commas, and the tokens
+// after it are the options, the unknown ones are kept to be reported by Validate.
+// If there is no known kind, the options are recognized from the end of tag and
+// the first token which is not an option is the unknown kind.
+func parseTag(s string) (t structTag) {
+	tokens := strings.Split(s, ",")
+	if len(tokens) < 2 {
 		return
 	}
-	if idx-1 > 1 {
-		key = s[:idx]
+
+	i := len(tokens) - 1
+	for i > 0 && !slices.Contains(tagKinds, tokens[i]) {
+		i--
+	}
+	if i == 0 {
+		i = len(tokens) - 1
+		for i > 1 && isTagOption(tokens[i]) {
+			i--
+		}
 	}
-	if idx+1 < len(s) {
-		val = s[idx+1:]
+	t.name = strings.Join(tokens[:i], ",")
+	t.kind = tokens[i]
+	if i+1 < len(tokens) {
+		t.opts = tokens[i+1:]
 	}
 	return
 }
 
-func escapeMeasurement(s string) string {
-	for _, v := range []string{",", " "} {
-		s = strings.ReplaceAll(s, v, "\\"+v)
+// hasOption reports whether the tag has the option.
+func (t structTag) hasOption(opt string) bool { return slices.Contains(t.opts, opt) }
+
+// option returns the value of key=value option of tag.
+func (t structTag) option(key string) (string, bool) {
+	for _, opt := range t.opts {
+		if k, v, ok := strings.Cut(opt, "="); ok && k == key {
+			return v, true
+		}
 	}
-	return s
+	return "", false
+}
+
+// coercion returns the type of value set by tag option, e.g. `influx:"rate,field,float"`,
+// InvalidValue if it is not set.
+func (t structTag) coercion() ValueKind {
+	for _, opt := range t.opts {
+		switch opt {
+		case "int":
+			return IntValue
+		case "uint":
+			return UintValue
+		case "float":
+			return FloatValue
+		case "string":
+			return StringValue
+		}
+	}
+	return InvalidValue
+}
+
+// precision returns the timestamp precision set by tag option, zero if it is not set.
+func (t structTag) precision() time.Duration {
+	for _, opt := range t.opts {
+		if d, ok := precisions[opt]; ok {
+			return d
+		}
+	}
+	return 0
+}
+
+// The special characters of line protocol elements, they are escaped with backslash.
+// The backslashes are escaped everywhere, so the escaped value never ends with
+// escaping backslash. The newlines terminate rows, they are not allowed in measurement,
+// tags and field keys (see validateKey) and are written as is in quoted string values.
+const (
+	measurementSpecials = ", \\"
+	keySpecials         = ",= \\"
+	fieldValueSpecials  = `"\`
+)
+
+// appendEscaped appends s to dst escaping the bytes of specials.
+func appendEscaped(dst []byte, s, specials string) []byte {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; strings.IndexByte(specials, c) >= 0 {
+			dst = append(dst, '\\', c)
+		} else {
+			dst = append(dst, c)
+		}
+	}
+	return dst
+}
+
+// appendMeasurement appends the escaped measurement s to dst.
+func appendMeasurement(dst []byte, s string) []byte {
+	return appendEscaped(dst, s, measurementSpecials)
 }
 
+// appendKey appends the escaped tag key or value or field key s to dst.
+func appendKey(dst []byte, s string) []byte { return appendEscaped(dst, s, keySpecials) }
+
+// appendFieldV appends the quoted string field value s to dst.
+func appendFieldV(dst []byte, s string) []byte {
+	dst = append(dst, '"')
+	dst = appendEscaped(dst, s, fieldValueSpecials)
+	return append(dst, '"')
+}
+
+// escapeTagKVFieldK returns the key s escaped by appendKey, s is returned
+// as is if there is nothing to escape.
 func escapeTagKVFieldK(s string) string {
-	for _, v := range []string{",", "=", " "} {
-		s = strings.ReplaceAll(s, v, "\\"+v)
+	if !strings.ContainsAny(s, keySpecials) {
+		return s
 	}
-	return s
+	return string(appendKey(nil, s))
+}
+
+// Errors returned by Marshal, use errors.Is to check them.
+var (
+	ErrMissingMeasurement = errors.New("`influx:\",measurement\"` not found")
+	ErrMissingTimestamp   = errors.New("`influx:\",timestamp\"` not found")
+	ErrNoFields           = errors.New("points must have at least one field")
+	ErrMarshalInflux      = errors.New("MarshalInflux error")
+	ErrUnsupportedType    = errors.New("unsupported type")
+	ErrMethodSignature    = errors.New("wrong method signature")
+	ErrInvalidPrecision   = errors.New("unsupported timestamp precision")
+)
+
+// encOpts holds the knobs changing the behaviour of marshaling.
+type encOpts struct {
+	// logFieldErrors makes the failed MarshalInflux calls to be logged with
+	// the standard logger and the field omitted instead of failing the point.
+	logFieldErrors bool
+	// validation is the policy of handling the tags and fields which are not
+	// allowed by line protocol, the placeholder is used by ValidationReplace.
+	validation  ValidationPolicy
+	placeholder string
+	// sortTags makes the tags to be sorted by keys.
+	sortTags bool
+	// precision is the precision of timestamps, zero means nanoseconds.
+	// The precision set by struct tag option takes precedence.
+	precision time.Duration
+	// optionalTimestamp makes the rows of points without timestamp to be written
+	// without it, the server assigns its own time to them. The now function, if set,
+	// is used to get the timestamp of such points instead.
+	optionalTimestamp bool
+	now               func() time.Time
+	// floatPoint makes the integer floats to be written with decimal point.
+	floatPoint bool
+	// strict makes the mistakes of struct tags to be errors, see Validate.
+	strict bool
+	// errorPolicy is the policy of handling the errors of fields and points,
+	// the skipped errors are passed to onError if it is set.
+	errorPolicy ErrorPolicy
+	onError     func(error)
 }
 
-func escapeFiledV(s string) string {
-	for _, v := range []string{`"`, `\`} {
-		s = strings.ReplaceAll(s, v, "\\"+v)
+// handle returns err unless it is skipped by policy, in the latter case
+// the error is passed to the error handler and nil is returned.
+func (opts *encOpts) handle(policy ErrorPolicy, err error) error {
+	if opts.errorPolicy != policy {
+		return err
+	}
+	if opts.onError != nil {
+		opts.onError(err)
 	}
-	s = fmt.Sprintf("%q", s)
-	return s
+	return nil
 }
 
 // Convert struct to influxdb line protocol.
 //
+// This is a thin wrapper of Marshal kept for compatibility: the errors are returned
+// as strings prefixed with "error: " and the fields which MarshalInflux method fails
+// are logged with the standard logger and omitted. The tags and fields which are not
+// allowed by line protocol (e.g. tags with empty values) are omitted too.
+//
 // The structs should describe their reflection to influx line protocol format with struct tags:
 // tag name represents the name of measurement, tag or field of line protocol row,
 // tag value represents the type of data: measurement, tag, field or timestamp.
@@ -62,101 +235,59 @@ func escapeFiledV(s string) string {
 //		Uptime time.Duration `influx:"uptime,field"`
 //		Timestamp time.Time `influx:",timestamp"` // name is omitted cos will not used
 //	}
+//
+// The timestamp is written in nanoseconds, the precision may be changed with the option
+// of struct tag: s, ms, us or ns, e.g. `influx:",timestamp,s"`. The zero timestamp
+// is an error unless the field is tagged with omitempty option, in this case the row
+// is written without timestamp and the server assigns its own time to the point.
 func ConvertToInfluxLineProtocol(v any) string {
-	var measurement string
-	var timestamp time.Time
-
-	vt := reflect.ValueOf(v)
-	at := reflect.TypeOf(v)
-
-	if ms := vt.MethodByName("InfluxMeasurement"); ms.Kind() == reflect.Func {
-		r := ms.Call([]reflect.Value{})
-		measurement = r[0].Interface().(string)
-	}
-	if tm := vt.MethodByName("InfluxTimestamp"); tm.Kind() == reflect.Func {
-		r := tm.Call([]reflect.Value{})
-		timestamp = r[0].Interface().(time.Time)
-	}
-
-	var metric = map[string][]string{
-		"tag":   {},
-		"field": {},
-	}
-
-	for i := range at.NumField() {
-		if tag, ok := at.Field(i).Tag.Lookup("influx"); ok {
-			k, v := extractTagKeyVal(tag)
-			metricName, metricType := escapeTagKVFieldK(k), v
-
-			switch metricType {
-			case "measurement":
-				measurement = fmt.Sprint(vt.FieldByName(at.Field(i).Name))
-			case "timestamp":
-				timestamp = vt.FieldByName(at.Field(i).Name).Interface().(time.Time)
-			default:
-				// Check for custom marshaling of current type:
-				if marshal, ok := at.Field(i).Type.MethodByName("MarshalInflux"); ok {
-					r := marshal.Func.Call([]reflect.Value{
-						vt.FieldByName(at.Field(i).Name),
-					})
-
-					val := r[0].Interface().(string)
-					err, ok := r[1].Interface().(error)
-					if ok && err != nil {
-						log.Printf(
-							"%s %q MarshalInflux error: %s", metricType, metricName, err)
-						continue
-					}
-					metric[metricType] = append(
-						metric[metricType], fmt.Sprintf("%s=%v", metricName, val))
-					continue
-				}
-
-				mval := fmt.Sprintf("%s=", metricName)
-
-				switch at.Field(i).Type.Kind() {
-				case reflect.String:
-					s := vt.FieldByName(at.Field(i).Name).Interface().(string)
-					if metricType == "tag" {
-						s = escapeTagKVFieldK(s)
-					} else if metricType == "field" {
-						s = escapeFiledV(s)
-					}
-					mval += s
-				case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-					mval += fmt.Sprintf("%vi", vt.FieldByName(at.Field(i).Name))
-				case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-					mval += fmt.Sprintf("%vu", vt.FieldByName(at.Field(i).Name))
-				default:
-					mval += fmt.Sprintf("%v", vt.FieldByName(at.Field(i).Name))
-				}
-				metric[metricType] = append(metric[metricType], mval)
-			}
-		}
-	}
-
-	if measurement == "" {
-		return "error: `influx:\",measurement\"` not found"
-	}
-
-	if timestamp.IsZero() {
-		return "error: `influx:\",timestamp\"` not found"
+	b, err := appendLine(nil, v, encOpts{logFieldErrors: true, validation: ValidationDrop})
+	if err != nil {
+		return "error: " + err.Error()
 	}
+	return string(b)
+}
 
-	if len(metric["field"]) == 0 {
-		return "error: points must have at least one field"
+// Marshal returns the influxdb line protocol encoding of v.
+//
+// See ConvertToInfluxLineProtocol for the description of struct tags.
+// Unlike ConvertToInfluxLineProtocol, the failure of any MarshalInflux method
+// fails the whole point with ErrMarshalInflux error, as well as the measurement, tags
+// and fields which are not allowed by line protocol: empty keys and values and keys
+// starting with underscore (ErrEmptyKey, ErrEmptyValue or ErrReservedKey). These
+// errors are FieldError naming the struct field. Use Encoder to change this behaviour.
+//
+// The tags are sorted by keys as recommended by InfluxDB for write performance,
+// ConvertToInfluxLineProtocol keeps the order of struct fields.
+//
+// The v may be a struct or a slice, array or channel of structs (or pointers to structs),
+// in the latter case the rows of elements are separated by newlines. The channel
+// is read until it is closed, even if the encoding of some element fails.
+func Marshal(v any) ([]byte, error) {
+	b, err := appendLine(nil, v, encOpts{sortTags: true})
+	if err != nil {
+		return nil, err
 	}
+	return b, nil
+}
 
-	measurement = escapeMeasurement(measurement)
-
-	if len(metric["tag"]) >= 1 {
-		return fmt.Sprintf(
-			"%s,%s %s %d", measurement, strings.Join(metric["tag"], ","),
-			strings.Join(metric["field"], ","), timestamp.UnixNano(),
-		)
+// AppendLine appends the influxdb line protocol encoding of v to dst and returns
+// the extended buffer, see Marshal for details. The row is not terminated by newline.
+// In case of error dst is returned unchanged.
+//
+// It does not allocate for the cached types having numeric, boolean and string
+// fields only (and time.Time timestamp) if dst has enough capacity, pass the pointer
+// to struct to avoid allocating a copy of it on conversion to any.
+func AppendLine(dst []byte, v any) ([]byte, error) {
+	b, err := appendLine(dst, v, encOpts{sortTags: true})
+	if err != nil {
+		return dst, err
 	}
+	return b, nil
+}
 
-	return fmt.Sprintf(
-		"%s %s %d", measurement, strings.Join(metric["field"], ","), timestamp.UnixNano(),
-	)
+// appendLine appends the line protocol rows of v to dst.
+func appendLine(dst []byte, v any, opts encOpts) ([]byte, error) {
+	dst, _, err := appendLines(dst, reflect.ValueOf(v), opts)
+	return dst, err
 }
@@ -3,34 +3,53 @@ package influx
 import (
 	"errors"
 	"log"
+	"slices"
 	"strconv"
 	"strings"
 	"testing"
 	"time"
 )
 
-func TestExtractTagKeyVal(t *testing.T) {
+func TestParseTag(t *testing.T) {
 	t.Parallel()
 
 	testCases := []struct {
 		Sample   string
-		Expected []string
+		Expected structTag
 	}{
-		{Sample: "key1,key2,key2,field", Expected: []string{"key1,key2,key2", "field"}},
-		{Sample: ",measurement", Expected: []string{"", "measurement"}},
-		{Sample: "key,field", Expected: []string{"key", "field"}},
-		{Sample: "key,", Expected: []string{"key", ""}},
-		{Sample: "", Expected: []string{"", ""}},
-		{Sample: ",", Expected: []string{"", ""}},
+		{Sample: "key1,key2,key2,field", Expected: structTag{name: "key1,key2,key2", kind: "field"}},
+		{Sample: ",measurement", Expected: structTag{kind: "measurement"}},
+		{Sample: "key,field", Expected: structTag{name: "key", kind: "field"}},
+		{Sample: "dc,tag", Expected: structTag{name: "dc", kind: "tag"}},
+		{Sample: "key,", Expected: structTag{name: "key"}},
+		{Sample: "", Expected: structTag{}},
+		{Sample: ",", Expected: structTag{}},
+		{Sample: "key", Expected: structTag{}},
+		{Sample: "dc,tag,omitempty", Expected: structTag{name: "dc", kind: "tag", opts: []string{"omitempty"}}},
+		{Sample: "a,b,field,omitempty", Expected: structTag{name: "a,b", kind: "field", opts: []string{"omitempty"}}},
+		{Sample: "a,omitempty,field", Expected: structTag{name: "a,omitempty", kind: "field"}},
+		{Sample: "time,field,unit=min,precision=2", Expected: structTag{name: "time", kind: "field", opts: []string{"unit=min", "precision=2"}}},
+		{Sample: "errors,feild,omitempty", Expected: structTag{name: "errors", kind: "feild", opts: []string{"omitempty"}}},
+		{Sample: "c,field,omitemtpy", Expected: structTag{name: "c", kind: "field", opts: []string{"omitemtpy"}}},
+		{Sample: "a,field,b,tag,omitempty,x", Expected: structTag{name: "a,field,b", kind: "tag", opts: []string{"omitempty", "x"}}},
+		{Sample: "net_,prefix", Expected: structTag{name: "net_", kind: "prefix"}},
+		{Sample: "k=v,tag", Expected: structTag{name: "k=v", kind: "tag"}},
+		{Sample: ",timestamp,ms", Expected: structTag{kind: "timestamp", opts: []string{"ms"}}},
+		{Sample: "ms,field", Expected: structTag{name: "ms", kind: "field"}},
+		{Sample: "latency,field,float", Expected: structTag{name: "latency", kind: "field", opts: []string{"float"}}},
+		{Sample: "string,field,string", Expected: structTag{name: "string", kind: "field", opts: []string{"string"}}},
 	}
 
 	for _, testCase := range testCases {
-		key, val := extractTagKeyVal(testCase.Sample)
-		if testCase.Expected[0] != key {
-			t.Errorf("expected %s, got: %s", testCase.Expected[0], key)
+		tag := parseTag(testCase.Sample)
+		if testCase.Expected.name != tag.name {
+			t.Errorf("%q: expected name %s, got: %s", testCase.Sample, testCase.Expected.name, tag.name)
 		}
-		if testCase.Expected[1] != val {
-			t.Errorf("expected %s, got: %s", testCase.Expected[1], val)
+		if testCase.Expected.kind != tag.kind {
+			t.Errorf("%q: expected kind %s, got: %s", testCase.Sample, testCase.Expected.kind, tag.kind)
+		}
+		if !slices.Equal(testCase.Expected.opts, tag.opts) {
+			t.Errorf("%q: expected options %v, got: %v", testCase.Sample, testCase.Expected.opts, tag.opts)
 		}
 	}
 }
@@ -182,7 +201,33 @@ func TestConvertToInfluxLineProtocol(t *testing.T) {
 			Field2: `hotel "Queen"`,
 		}
 
-		expected := `escape\ measurement field1="va\\\\l1",field2="hotel \\\\\"Queen\\\\\"" ` + strconv.FormatInt(ts.UnixNano(), 10)
+		expected := `escape\ measurement field1="va\\l1",field2="hotel \"Queen\"" ` + strconv.FormatInt(ts.UnixNano(), 10)
+		row := ConvertToInfluxLineProtocol(v)
+		if expected != row {
+			t.Errorf("expected: %s, got: %s", expected, row)
+		}
+	})
+
+	t.Run("escape/special", func(t *testing.T) {
+		ts := time.Now()
+
+		v := struct {
+			Ts time.Time `influx:",timestamp"`
+			Ms string    `influx:",measurement"`
+
+			Tag   string `influx:"tag\\key,tag"`
+			Lines string `influx:"lines,tag"`
+			Field string `influx:"field,field"`
+		}{
+			Ms:    "escape\\measurement\\",
+			Ts:    ts,
+			Tag:   "value\\",
+			Lines: "line1\nline2", // newlines are not allowed in tags
+			Field: "line1\n\tline2 \\ ü",
+		}
+
+		expected := `escape\\measurement\\,tag\\key=value\\ field="line1` + "\n\t" + `line2 \\ ü" ` +
+			strconv.FormatInt(ts.UnixNano(), 10)
 		row := ConvertToInfluxLineProtocol(v)
 		if expected != row {
 			t.Errorf("expected: %s, got: %s", expected, row)
@@ -228,7 +273,7 @@ func TestConvertToInfluxLineProtocol(t *testing.T) {
 			Float64: 2123423424.34345531,
 		}
 
-		expected := "floats field1=56365.234,field2=2.1234234243434553e+09 " + strconv.FormatInt(ts.UnixNano(), 10)
+		expected := "floats field1=56365.234,field2=2123423424.3434553 " + strconv.FormatInt(ts.UnixNano(), 10)
 
 		row := ConvertToInfluxLineProtocol(v)
 		if expected != row {
@@ -631,3 +676,129 @@ func TestMarshalInflux(t *testing.T) {
 		}
 	})
 }
+
+func TestMarshalBytes(t *testing.T) {
+	t.Parallel()
+
+	t.Run("ok", func(t *testing.T) {
+		ts := time.Now()
+
+		v := TestMarshal{
+			Name:      "starship",
+			Timestamp: ts,
+			Weight:    5000,
+			Sensor:    "onboard,45.16",
+		}
+
+		expected := "starship weight=5000i,temperature=45.16 " + strconv.FormatInt(ts.UnixNano(), 10)
+		row, err := Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected != string(row) {
+			t.Errorf("expected: %s, got: %s", expected, row)
+		}
+	})
+
+	t.Run("error/measurement", func(t *testing.T) {
+		v := struct {
+			Timestamp time.Time `influx:",timestamp"`
+			Errors    int       `influx:"errors,field"`
+		}{Timestamp: time.Now(), Errors: 1}
+
+		row, err := Marshal(v)
+		if !errors.Is(err, ErrMissingMeasurement) {
+			t.Errorf("expected ErrMissingMeasurement, got: %v", err)
+		}
+		if row != nil {
+			t.Errorf("expected nil, got: %s", row)
+		}
+	})
+
+	t.Run("error/timestamp", func(t *testing.T) {
+		v := struct {
+			Mode   string `influx:",measurement"`
+			Errors int    `influx:"errors,field"`
+		}{Mode: "aggregate", Errors: 1}
+
+		_, err := Marshal(v)
+		if !errors.Is(err, ErrMissingTimestamp) {
+			t.Errorf("expected ErrMissingTimestamp, got: %v", err)
+		}
+	})
+
+	t.Run("error/field", func(t *testing.T) {
+		v := struct {
+			Mode      string    `influx:",measurement"`
+			Timestamp time.Time `influx:",timestamp"`
+			Errors    int
+		}{Mode: "aggregate", Timestamp: time.Now(), Errors: 1}
+
+		_, err := Marshal(v)
+		if !errors.Is(err, ErrNoFields) {
+			t.Errorf("expected ErrNoFields, got: %v", err)
+		}
+	})
+
+	t.Run("error/marshal", func(t *testing.T) {
+		v := TestMarshal{
+			Name:      "starship",
+			Timestamp: time.Now(),
+			Weight:    5000,
+			Sensor:    "onboard=45.16",
+		}
+
+		_, err := Marshal(v)
+		if !errors.Is(err, ErrMarshalInflux) {
+			t.Errorf("expected ErrMarshalInflux, got: %v", err)
+		}
+		expected := `influx.TestMarshal.Sensor: MarshalInflux error: field "temperature": wrong format`
+		if err == nil || err.Error() != expected {
+			t.Errorf("expected: %s, got: %v", expected, err)
+		}
+	})
+}
+
+func TestEscape(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		Value, Measurement, Key, FieldValue string
+	}{
+		{Value: "cpu", Measurement: "cpu", Key: "cpu", FieldValue: `"cpu"`},
+		{Value: "a b,c=d", Measurement: `a\ b\,c=d`, Key: `a\ b\,c\=d`, FieldValue: `"a b,c=d"`},
+		{Value: `a\`, Measurement: `a\\`, Key: `a\\`, FieldValue: `"a\\"`},
+		{Value: `say "hi"`, Measurement: `say\ "hi"`, Key: `say\ "hi"`, FieldValue: `"say \"hi\""`},
+		{Value: "a\nb\tc", Measurement: "a\nb\tc", Key: "a\nb\tc", FieldValue: "\"a\nb\tc\""},
+		{Value: "日本", Measurement: "日本", Key: "日本", FieldValue: `"日本"`},
+	}
+
+	for _, testCase := range testCases {
+		if got := string(appendMeasurement(nil, testCase.Value)); testCase.Measurement != got {
+			t.Errorf("measurement: expected: %s, got: %s", testCase.Measurement, got)
+		}
+		if got := string(appendKey(nil, testCase.Value)); testCase.Key != got {
+			t.Errorf("key: expected: %s, got: %s", testCase.Key, got)
+		}
+		if got := string(appendFieldV(nil, testCase.Value)); testCase.FieldValue != got {
+			t.Errorf("field value: expected: %s, got: %s", testCase.FieldValue, got)
+		}
+	}
+}
+
+func FuzzEscape(f *testing.F) {
+	for _, s := range []string{"cpu", "a b,c=d", `a\`, `\\,`, `say "hi"`, "a\nb\tc", "日本", `\n`} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		if got := unescape(string(appendMeasurement(nil, s)), ", "); s != got {
+			t.Errorf("measurement: expected: %q, got: %q", s, got)
+		}
+		if got := unescapeKey(string(appendKey(nil, s))); s != got {
+			t.Errorf("key: expected: %q, got: %q", s, got)
+		}
+		if got := unescapeFieldV(string(appendFieldV(nil, s))); s != got {
+			t.Errorf("field value: expected: %q, got: %q", s, got)
+		}
+	})
+}
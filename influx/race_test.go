@@ -0,0 +1,7 @@
+//go:build race
+
+package influx
+
+// raceEnabled is set if the tests run with race detector, which makes
+// sync.Pool to drop items randomly.
+const raceEnabled = true
@@ -1,7 +1,8 @@
 package influx
 
 import (
-	"strconv"
+	"fmt"
+	"math"
 	"time"
 )
 
@@ -10,25 +11,67 @@ type Duration struct {
 	To    time.Duration
 }
 
-func (d Duration) MarshalInflux() (string, error) {
+// MarshalInfluxValue returns the duration in units of To: integer nanoseconds,
+// microseconds and milliseconds or float seconds, minutes and hours with two
+// digits after the decimal point. The value is returned as string if To is not
+// one of these units.
+func (d Duration) MarshalInfluxValue() (Value, error) {
 	duration, err := time.ParseDuration(string(d.Value))
 	if err != nil {
-		return "", err
+		return Value{}, err
 	}
 	switch d.To {
 	case time.Nanosecond:
-		return strconv.FormatInt(duration.Nanoseconds(), 10) + "i", nil
+		return Int(duration.Nanoseconds()), nil
 	case time.Microsecond:
-		return strconv.FormatInt(duration.Microseconds(), 10) + "i", nil
+		return Int(duration.Microseconds()), nil
 	case time.Millisecond:
-		return strconv.FormatInt(duration.Milliseconds(), 10) + "i", nil
+		return Int(duration.Milliseconds()), nil
 	case time.Second:
-		return strconv.FormatFloat(duration.Seconds(), 'f', 2, 64), nil
+		return FixedFloat(duration.Seconds(), 2), nil
 	case time.Minute:
-		return strconv.FormatFloat(duration.Minutes(), 'f', 2, 64), nil
+		return FixedFloat(duration.Minutes(), 2), nil
 	case time.Hour:
-		return strconv.FormatFloat(duration.Hours(), 'f', 2, 64), nil
+		return FixedFloat(duration.Hours(), 2), nil
 	default:
+		return String(d.Value), nil
+	}
+}
+
+// MarshalInflux is kept for compatibility, the encoder uses MarshalInfluxValue.
+// Unlike the latter it returns the value as is if To is not a known unit.
+func (d Duration) MarshalInflux() (string, error) {
+	v, err := d.MarshalInfluxValue()
+	if err != nil {
+		return "", err
+	}
+	if v.Kind() == StringValue {
 		return d.Value, nil // return the value as is
 	}
+	b, err := v.appendTo(nil, kindField, false)
+	return string(b), err
+}
+
+// UnmarshalInfluxValue implements ValueUnmarshaler. The numbers are read in units
+// of To, which should be set before decoding, nanoseconds if it is zero, and stored
+// as the duration string, e.g. 1.50 of seconds is stored as "1.5s". The strings
+// are stored as is.
+func (d *Duration) UnmarshalInfluxValue(v Value) error {
+	unit := d.To
+	if unit <= 0 {
+		unit = time.Nanosecond
+	}
+	switch v.Kind() {
+	case IntValue:
+		d.Value = (time.Duration(v.Interface().(int64)) * unit).String()
+	case UintValue:
+		d.Value = (time.Duration(v.Interface().(uint64)) * unit).String()
+	case FloatValue:
+		d.Value = time.Duration(math.Round(v.Interface().(float64) * float64(unit))).String()
+	case StringValue:
+		d.Value = v.Interface().(string)
+	default:
+		return fmt.Errorf("%s is not a duration", v.Kind())
+	}
+	return nil
 }
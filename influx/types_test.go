@@ -91,4 +91,25 @@ func TestDuration(t *testing.T) {
 			t.Errorf("expected 12.50, got: %s", influxRepr)
 		}
 	})
+	t.Run("value", func(t *testing.T) {
+		testCases := []struct {
+			Sample   Duration
+			Expected Value
+		}{
+			{Sample: Duration{Value: "12ms", To: time.Millisecond}, Expected: Int(12)},
+			{Sample: Duration{Value: "12m30.223434567s", To: time.Minute}, Expected: FixedFloat(12.50372391, 2)},
+			{Sample: Duration{Value: "13h23m12s"}, Expected: String("13h23m12s")},
+		}
+		for _, testCase := range testCases {
+			v, err := testCase.Sample.MarshalInfluxValue()
+			if err != nil {
+				t.Error(err)
+			}
+			expected, _ := testCase.Expected.appendTo(nil, kindField, false)
+			got, _ := v.appendTo(nil, kindField, false)
+			if v.Kind() != testCase.Expected.Kind() || string(expected) != string(got) {
+				t.Errorf("expected: %s, got: %s", expected, got)
+			}
+		}
+	})
 }
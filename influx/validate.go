@@ -0,0 +1,132 @@
+package influx
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Errors of line protocol validation, use errors.Is to check them.
+var (
+	ErrEmptyKey        = errors.New("empty key")
+	ErrReservedKey     = errors.New("key starts with underscore")
+	ErrNewline         = errors.New("newline is not allowed")
+	ErrCommentLine     = errors.New("measurement starts with # or tab")
+	ErrEmptyValue      = errors.New("empty value")
+	ErrNonFiniteFloat  = errors.New("NaN or Inf float")
+	ErrLossyConversion = errors.New("lossy conversion")
+)
+
+// Errors of struct tags reported by Validate and in strict mode, they are wrapped
+// by FieldError naming the struct field.
+var (
+	ErrUnknownKind     = errors.New("unknown kind")
+	ErrDuplicate       = errors.New("duplicate")
+	ErrUnexportedField = errors.New("unexported field")
+	ErrUnknownOption   = errors.New("unknown option")
+)
+
+// ValidationPolicy defines what to do with the tags and fields which are not allowed
+// by line protocol: keys starting with underscore (this namespace is reserved
+// for InfluxDB system use), empty keys and empty values, newlines in keys and tag
+// values, NaN and Inf floats. The measurement starting with # (the row would be
+// a comment) or tab (it would be trimmed) is not allowed too.
+type ValidationPolicy int
+
+const (
+	// ValidationError fails the point with FieldError.
+	ValidationError ValidationPolicy = iota
+	// ValidationDrop omits the invalid tags and fields.
+	ValidationDrop
+	// ValidationReplace trims the leading underscores of keys (and # and tabs of
+	// measurement) and replaces empty keys and values with the placeholder, the point
+	// fails if this does not help.
+	// The NaN and Inf floats are omitted.
+	ValidationReplace
+)
+
+// FieldError is the error of encoding of struct field.
+type FieldError struct {
+	Struct string // type of struct, e.g. "main.Node"
+	Field  string // name of struct field, nested fields are separated by dots
+	Err    error
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%s.%s: %s", e.Struct, e.Field, e.Err)
+}
+
+func (e *FieldError) Unwrap() error { return e.Err }
+
+// validateKey checks the measurement, tag key or field key.
+func validateKey(key string) error {
+	if key == "" {
+		return ErrEmptyKey
+	}
+	if key[0] == '_' {
+		return ErrReservedKey
+	}
+	if strings.IndexByte(key, '\n') >= 0 {
+		return ErrNewline
+	}
+	return nil
+}
+
+// validateMeasurement checks the measurement, unlike the keys it may not start
+// with # or tab: such line is a comment or the tab is trimmed by parser.
+func validateMeasurement(m string) error {
+	if m != "" && (m[0] == '#' || m[0] == '\t') {
+		return ErrCommentLine
+	}
+	return validateKey(m)
+}
+
+// replaceKey fixes the invalid key according to ValidationReplace policy.
+func replaceKey(key, placeholder string) (string, error) {
+	if key = strings.TrimLeft(key, "_"); key == "" {
+		key = placeholder
+	}
+	return key, validateKey(key)
+}
+
+// Validate checks the struct tags of type of v, which is a struct or a slice, array
+// or channel of structs (or pointers to any of them), v may be nil pointer. Besides
+// the errors returned by Marshal for unsupported types, it reports the mistakes which
+// are ignored by default:
+//
+//   - unknown kinds of data, e.g. `influx:"errors,feild"` (ErrUnknownKind),
+//   - duplicate keys of tags or fields and multiple measurement or timestamp fields
+//     (ErrDuplicate),
+//   - tagged unexported fields (ErrUnexportedField),
+//   - unknown options, e.g. `influx:"count,field,omitemtpy"` or `influx:"time,field,unti=min"`,
+//     and the options which do not apply to the kind of data, e.g. the precision of field
+//     `influx:"errors,field,ms"` (ErrUnknownOption), such options are ignored but the field
+//     is encoded.
+//
+// The mistakes are joined to one error, every mistake is FieldError. Use
+// Encoder.SetStrict to make the encoder to report them.
+func Validate(v any) error {
+	t := reflect.TypeOf(v)
+	if t == nil {
+		return fmt.Errorf("%w: nil", ErrUnsupportedType)
+	}
+	t = indirectType(t)
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Chan:
+		t = indirectType(t.Elem())
+	}
+	if t.Kind() != reflect.Struct {
+		return fmt.Errorf("%w: %s", ErrUnsupportedType, t)
+	}
+	_, err := planOf(t, encOpts{strict: true})
+	return err
+}
+
+// replaceMeasurement fixes the invalid measurement according to ValidationReplace policy.
+func replaceMeasurement(m, placeholder string) (string, error) {
+	if m = strings.TrimLeft(m, "_#\t"); m == "" {
+		m = placeholder
+	}
+	return m, validateMeasurement(m)
+}
@@ -0,0 +1,269 @@
+package influx
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type TestInvalid struct {
+	Name   string            `influx:",measurement"`
+	DC     string            `influx:"dc,tag"`
+	Host   string            `influx:"_host,tag"`
+	Errors int               `influx:"errors,field"`
+	Labels map[string]string `influx:",tags"`
+	Ts     time.Time         `influx:",timestamp"`
+}
+
+func TestValidation(t *testing.T) {
+	t.Parallel()
+
+	ts := time.Now()
+	v := TestInvalid{
+		Name: "node", Host: "web-1", Errors: 1, Ts: ts,
+		Labels: map[string]string{"": "x", "_id": "12", "zone": ""},
+	}
+	suffix := " " + strconv.FormatInt(ts.UnixNano(), 10)
+
+	t.Run("error", func(t *testing.T) {
+		_, err := Marshal(v)
+		if !errors.Is(err, ErrEmptyValue) {
+			t.Errorf("expected ErrEmptyValue, got: %v", err)
+		}
+		var fieldErr *FieldError
+		if !errors.As(err, &fieldErr) {
+			t.Fatalf("expected FieldError, got: %v", err)
+		}
+		if fieldErr.Struct != "influx.TestInvalid" || fieldErr.Field != "DC" {
+			t.Errorf("unexpected struct field: %s.%s", fieldErr.Struct, fieldErr.Field)
+		}
+		if expected := `influx.TestInvalid.DC: empty value of tag "dc"`; expected != err.Error() {
+			t.Errorf("expected: %s, got: %s", expected, err)
+		}
+	})
+
+	t.Run("error/key", func(t *testing.T) {
+		v := v
+		v.DC = "east-1"
+		_, err := Marshal(v)
+		if !errors.Is(err, ErrReservedKey) {
+			t.Errorf("expected ErrReservedKey, got: %v", err)
+		}
+		if expected := `influx.TestInvalid.Host: key starts with underscore of tag "_host"`; err == nil || expected != err.Error() {
+			t.Errorf("expected: %s, got: %v", expected, err)
+		}
+	})
+
+	t.Run("drop", func(t *testing.T) {
+		var buf strings.Builder
+		enc := NewEncoder(&buf)
+		enc.SetValidation(ValidationDrop, "")
+		if err := enc.Encode(v); err != nil {
+			t.Fatal(err)
+		}
+		if expected := "node errors=1i" + suffix + "\n"; expected != buf.String() {
+			t.Errorf("expected: %s, got: %s", expected, buf.String())
+		}
+	})
+
+	t.Run("replace", func(t *testing.T) {
+		var buf strings.Builder
+		enc := NewEncoder(&buf)
+		enc.SetValidation(ValidationReplace, "n/a")
+		if err := enc.Encode(v); err != nil {
+			t.Fatal(err)
+		}
+		expected := "node,dc=n/a,host=web-1,id=12,n/a=x,zone=n/a errors=1i" + suffix + "\n"
+		if expected != buf.String() {
+			t.Errorf("expected: %s, got: %s", expected, buf.String())
+		}
+	})
+
+	t.Run("replace/empty", func(t *testing.T) {
+		enc := NewEncoder(&strings.Builder{})
+		enc.SetValidation(ValidationReplace, "")
+		if err := enc.Encode(v); !errors.Is(err, ErrEmptyValue) {
+			t.Errorf("expected ErrEmptyValue, got: %v", err)
+		}
+	})
+
+	t.Run("legacy", func(t *testing.T) {
+		if expected := "node errors=1i" + suffix; expected != ConvertToInfluxLineProtocol(v) {
+			t.Errorf("expected: %s, got: %s", expected, ConvertToInfluxLineProtocol(v))
+		}
+	})
+
+	type point struct {
+		Name   string            `influx:",measurement"`
+		DC     string            `influx:"dc,tag"`
+		Labels map[string]string `influx:",tags"`
+		Errors int               `influx:"errors,field"`
+		Ts     time.Time         `influx:",timestamp"`
+	}
+
+	t.Run("newline", func(t *testing.T) {
+		for _, v := range []point{
+			{Name: "node", DC: "east\n1", Errors: 1, Ts: ts},
+			{Name: "node", DC: "east-1", Labels: map[string]string{"zone\na": "x"}, Errors: 1, Ts: ts},
+			{Name: "node\n", DC: "east-1", Errors: 1, Ts: ts},
+		} {
+			if _, err := Marshal(v); !errors.Is(err, ErrNewline) {
+				t.Errorf("%+v: expected ErrNewline, got: %v", v, err)
+			}
+		}
+
+		var buf strings.Builder
+		enc := NewEncoder(&buf)
+		enc.SetValidation(ValidationDrop, "")
+		if err := enc.Encode(point{Name: "node", DC: "east\n1", Errors: 1, Ts: ts}); err != nil {
+			t.Fatal(err)
+		}
+		if expected := "node errors=1i" + suffix + "\n"; expected != buf.String() {
+			t.Errorf("expected: %s, got: %s", expected, buf.String())
+		}
+	})
+
+	t.Run("comment", func(t *testing.T) {
+		for _, name := range []string{"#node", "\tnode", "_#node"} {
+			v := point{Name: name, DC: "east-1", Errors: 1, Ts: ts}
+			if _, err := Marshal(v); !errors.Is(err, ErrCommentLine) && !errors.Is(err, ErrReservedKey) {
+				t.Errorf("%q: expected ErrCommentLine, got: %v", name, err)
+			}
+
+			var buf strings.Builder
+			enc := NewEncoder(&buf)
+			enc.SetValidation(ValidationReplace, "")
+			if err := enc.Encode(v); err != nil {
+				t.Fatal(err)
+			}
+			if expected := "node,dc=east-1 errors=1i" + suffix + "\n"; expected != buf.String() {
+				t.Errorf("expected: %s, got: %s", expected, buf.String())
+			}
+		}
+	})
+
+	t.Run("measurement", func(t *testing.T) {
+		v := TestInvalid{Name: "__node", DC: "east-1", Host: "web-1", Errors: 1, Ts: ts}
+		if _, err := Marshal(v); !errors.Is(err, ErrReservedKey) {
+			t.Errorf("expected ErrReservedKey, got: %v", err)
+		}
+
+		var buf strings.Builder
+		enc := NewEncoder(&buf)
+		enc.SetValidation(ValidationDrop, "")
+		err := enc.Encode(v)
+		if expected := `influx.TestInvalid.Name: key starts with underscore of measurement "__node"`; !errors.Is(err, ErrReservedKey) ||
+			err == nil || expected != err.Error() {
+			t.Errorf("expected: %s, got: %v", expected, err)
+		}
+
+		enc.SetValidation(ValidationReplace, "")
+		if err := enc.Encode(&v); err != nil {
+			t.Fatal(err)
+		}
+		if expected := "node,dc=east-1,host=web-1 errors=1i" + suffix + "\n"; expected != buf.String() {
+			t.Errorf("expected: %s, got: %s", expected, buf.String())
+		}
+	})
+}
+
+type TestStrict struct {
+	CommonTags
+	Name    string    `influx:",measurement"`
+	Node    string    `influx:",measurement"`
+	Host    string    `influx:"host,tag"`
+	Errors  int       `influx:"errors,feild"`
+	Count   int       `influx:"count,field"`
+	Total   int       `influx:"count,field"`
+	retries int       `influx:"retries,field"`
+	Ts      time.Time `influx:",timestamp"`
+}
+
+func TestValidate(t *testing.T) {
+	t.Parallel()
+
+	err := Validate(TestStrict{})
+	for _, target := range []error{ErrUnknownKind, ErrDuplicate, ErrUnexportedField} {
+		if !errors.Is(err, target) {
+			t.Errorf("expected %s, got: %v", target, err)
+		}
+	}
+	expected := `influx.TestStrict.Errors: unknown kind "feild"` + "\n" +
+		`influx.TestStrict.retries: unexported field` + "\n" +
+		`influx.TestStrict.Node: duplicate measurement, see Name` + "\n" +
+		`influx.TestStrict.Host: duplicate tag "host", see CommonTags.Host` + "\n" +
+		`influx.TestStrict.Total: duplicate field "count", see Count`
+	if err == nil || expected != err.Error() {
+		t.Errorf("expected: %s, got: %v", expected, err)
+	}
+
+	var fieldErr *FieldError
+	if !errors.As(err, &fieldErr) || fieldErr.Field != "Errors" {
+		t.Errorf("expected FieldError of Errors, got: %v", err)
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		for _, sample := range []any{benchPoint{}, &benchPoint{}, (*benchPoint)(nil), []*benchPoint{}, [1]TestNested{}} {
+			if err := Validate(sample); err != nil {
+				t.Errorf("%T: unexpected error: %v", sample, err)
+			}
+		}
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		for _, sample := range []any{nil, 12, []int{}, TestCycle{}} {
+			if err := Validate(sample); !errors.Is(err, ErrUnsupportedType) {
+				t.Errorf("%T: expected ErrUnsupportedType, got: %v", sample, err)
+			}
+		}
+	})
+
+	t.Run("options", func(t *testing.T) {
+		type options struct {
+			Name    string        `influx:",measurement,omitempty"`
+			Time    time.Duration `influx:"t,field,unti=min"`
+			Errors  int           `influx:"e,field,ms"`
+			Elapsed time.Duration `influx:"elapsed,field,unit=s,precision=2,omitempty"`
+			Count   int           `influx:"c,field,omitemtpy"`
+			Ts      time.Time     `influx:",timestamp,ms,float"`
+		}
+		err := Validate(options{})
+		if !errors.Is(err, ErrUnknownOption) {
+			t.Errorf("expected ErrUnknownOption, got: %v", err)
+		}
+		expected := `influx.options.Name: unknown option "omitempty" of measurement` + "\n" +
+			`influx.options.Time: unknown option "unti=min" of field` + "\n" +
+			`influx.options.Errors: unknown option "ms" of field` + "\n" +
+			`influx.options.Count: unknown option "omitemtpy" of field` + "\n" +
+			`influx.options.Ts: unknown option "float" of timestamp`
+		if err == nil || expected != err.Error() {
+			t.Errorf("expected: %s, got: %v", expected, err)
+		}
+
+		row, err := Marshal(options{Name: "m", Ts: time.Unix(1, 0)})
+		if expected := "m t=0i,e=0i,c=0i 1000"; err != nil || expected != string(row) {
+			t.Errorf("expected: %s, got: %s (%v)", expected, row, err)
+		}
+	})
+
+	t.Run("strict", func(t *testing.T) {
+		v := TestStrict{
+			CommonTags: CommonTags{Region: "eu", Host: "web-1", Service: "api"},
+			Name:       "node", Node: "node", Host: "web-1", Count: 1, Ts: time.Now(),
+		}
+		if _, err := Marshal(v); err != nil {
+			t.Fatal(err)
+		}
+
+		enc := NewEncoder(&strings.Builder{})
+		enc.SetStrict(true)
+		if err := enc.Encode(v); !errors.Is(err, ErrDuplicate) {
+			t.Errorf("expected ErrDuplicate, got: %v", err)
+		}
+		if err := enc.Encode([]TestStrict{v}); !errors.Is(err, ErrDuplicate) {
+			t.Errorf("expected ErrDuplicate, got: %v", err)
+		}
+	})
+}
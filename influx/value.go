@@ -0,0 +1,235 @@
+package influx
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// ValueKind is the type of line protocol field value.
+type ValueKind uint8
+
+const (
+	InvalidValue ValueKind = iota // the zero Value, it is treated as empty
+	IntValue
+	UintValue
+	FloatValue
+	BoolValue
+	StringValue
+)
+
+func (k ValueKind) String() string {
+	switch k {
+	case IntValue:
+		return "int"
+	case UintValue:
+		return "uint"
+	case FloatValue:
+		return "float"
+	case BoolValue:
+		return "bool"
+	case StringValue:
+		return "string"
+	}
+	return "invalid"
+}
+
+// Value is the typed value of tag or field returned by MarshalInfluxValue method,
+// unlike the string returned by MarshalInflux it is formatted and escaped by encoder.
+// Use Int, Uint, Float, FixedFloat, Bool and String functions to make it.
+type Value struct {
+	kind ValueKind
+	num  uint64 // bits of int, uint, float or bool
+	str  string
+	prec int  // number of digits after the decimal point of float, -1 means shortest
+	f32  bool // float is formatted as float32
+}
+
+// Int returns the integer Value, it is written with i suffix.
+func Int(v int64) Value { return Value{kind: IntValue, num: uint64(v)} }
+
+// Uint returns the unsigned integer Value, it is written with u suffix.
+func Uint(v uint64) Value { return Value{kind: UintValue, num: v} }
+
+// Float returns the float Value, it is written in the shortest form.
+func Float(v float64) Value { return Value{kind: FloatValue, num: math.Float64bits(v), prec: -1} }
+
+// float32Value returns the float Value of float32, it is written in the shortest
+// form of float32.
+func float32Value(v float32) Value {
+	return Value{kind: FloatValue, num: math.Float64bits(float64(v)), prec: -1, f32: true}
+}
+
+// FixedFloat returns the float Value written with prec digits after the decimal point,
+// e.g. FixedFloat(12.5, 2) is written as 12.50.
+func FixedFloat(v float64, prec int) Value {
+	return Value{kind: FloatValue, num: math.Float64bits(v), prec: prec}
+}
+
+// Bool returns the boolean Value.
+func Bool(v bool) Value {
+	if v {
+		return Value{kind: BoolValue, num: 1}
+	}
+	return Value{kind: BoolValue}
+}
+
+// String returns the string Value, it is quoted and escaped in fields.
+func String(v string) Value { return Value{kind: StringValue, str: v} }
+
+// Kind returns the type of v.
+func (v Value) Kind() ValueKind { return v.kind }
+
+// Interface returns v as int64, uint64, float64, bool or string, nil for the zero Value.
+func (v Value) Interface() any {
+	switch v.kind {
+	case IntValue:
+		return int64(v.num)
+	case UintValue:
+		return v.num
+	case FloatValue:
+		return math.Float64frombits(v.num)
+	case BoolValue:
+		return v.num != 0
+	case StringValue:
+		return v.str
+	}
+	return nil
+}
+
+// MarshalInfluxValue implements ValueMarshaler, so the fields may be of Value type.
+func (v Value) MarshalInfluxValue() (Value, error) { return v, nil }
+
+// UnmarshalInfluxValue implements ValueUnmarshaler, so the fields of Value type
+// may be decoded: the value is stored as parsed, e.g. 1.0 is read as Float(1).
+func (v *Value) UnmarshalInfluxValue(val Value) error {
+	*v = val
+	return nil
+}
+
+// appendTo appends v as the value of tag or field of kind k to dst, see appendFloat
+// for the formatting of floats. The tag values are strings, so the integers
+// are written without type suffix in tags.
+func (v Value) appendTo(dst []byte, k metricKind, floatPoint bool) ([]byte, error) {
+	switch v.kind {
+	case IntValue:
+		return appendInt(dst, int64(v.num), k), nil
+	case UintValue:
+		return appendUint(dst, v.num, k), nil
+	case FloatValue:
+		return appendFloat(dst, math.Float64frombits(v.num), v.bits(), v.prec, floatPoint)
+	case BoolValue:
+		return strconv.AppendBool(dst, v.num != 0), nil
+	case StringValue:
+		if k == kindTag {
+			return appendKey(dst, v.str), nil
+		}
+		return appendFieldV(dst, v.str), nil
+	}
+	return dst, nil
+}
+
+// appendInt appends the integer value of tag or field of kind k to dst.
+func appendInt(dst []byte, n int64, k metricKind) []byte {
+	dst = strconv.AppendInt(dst, n, 10)
+	if k == kindTag {
+		return dst
+	}
+	return append(dst, 'i')
+}
+
+// appendUint appends the unsigned integer value of tag or field of kind k to dst.
+func appendUint(dst []byte, n uint64, k metricKind) []byte {
+	dst = strconv.AppendUint(dst, n, 10)
+	if k == kindTag {
+		return dst
+	}
+	return append(dst, 'u')
+}
+
+// bits returns the bit size of float.
+func (v Value) bits() int {
+	if v.f32 {
+		return 32
+	}
+	return 64
+}
+
+// convert converts v to the Value of kind k, ErrLossyConversion is returned
+// if the conversion loses data, e.g. the float is not integer or the string
+// is not a number. The zero Value is returned as is.
+func (v Value) convert(k ValueKind) (Value, error) {
+	if v.kind == k || v.kind == InvalidValue {
+		return v, nil
+	}
+
+	switch k {
+	case IntValue:
+		switch v.kind {
+		case UintValue:
+			if v.num <= math.MaxInt64 {
+				return Int(int64(v.num)), nil
+			}
+		case FloatValue:
+			f := math.Float64frombits(v.num)
+			if f == math.Trunc(f) && f >= math.MinInt64 && f < math.MaxInt64 {
+				return Int(int64(f)), nil
+			}
+		case BoolValue:
+			return Int(int64(v.num)), nil
+		case StringValue:
+			if n, err := strconv.ParseInt(v.str, 10, 64); err == nil {
+				return Int(n), nil
+			}
+		}
+	case UintValue:
+		switch v.kind {
+		case IntValue:
+			if int64(v.num) >= 0 {
+				return Uint(v.num), nil
+			}
+		case FloatValue:
+			f := math.Float64frombits(v.num)
+			if f == math.Trunc(f) && f >= 0 && f < math.MaxUint64 {
+				return Uint(uint64(f)), nil
+			}
+		case BoolValue:
+			return Uint(v.num), nil
+		case StringValue:
+			if n, err := strconv.ParseUint(v.str, 10, 64); err == nil {
+				return Uint(n), nil
+			}
+		}
+	case FloatValue:
+		switch v.kind {
+		case IntValue:
+			// float64 holds integers up to 2^53 exactly, the bigger ones are checked
+			if f := float64(int64(v.num)); f < math.MaxInt64 && int64(f) == int64(v.num) {
+				return Float(f), nil
+			}
+		case UintValue:
+			if f := float64(v.num); f < math.MaxUint64 && uint64(f) == v.num {
+				return Float(f), nil
+			}
+		case BoolValue:
+			return Float(float64(v.num)), nil
+		case StringValue:
+			if f, err := strconv.ParseFloat(v.str, 64); err == nil {
+				return Float(f), nil
+			}
+		}
+	case StringValue:
+		switch v.kind {
+		case IntValue:
+			return String(strconv.FormatInt(int64(v.num), 10)), nil
+		case UintValue:
+			return String(strconv.FormatUint(v.num, 10)), nil
+		case FloatValue:
+			b, err := appendFloat(nil, math.Float64frombits(v.num), v.bits(), v.prec, false)
+			return String(string(b)), err
+		case BoolValue:
+			return String(strconv.FormatBool(v.num != 0)), nil
+		}
+	}
+	return Value{}, fmt.Errorf("%w: %s %v to %s", ErrLossyConversion, v.kind, v.Interface(), k)
+}
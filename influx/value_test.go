@@ -0,0 +1,312 @@
+package influx
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValue(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		Sample    Value
+		Kind      ValueKind
+		Interface any
+		Tag       string
+		Field     string
+	}{
+		{Sample: Int(-12), Kind: IntValue, Interface: int64(-12), Tag: "-12", Field: "-12i"},
+		{Sample: Uint(math.MaxUint64), Kind: UintValue, Interface: uint64(math.MaxUint64), Tag: "18446744073709551615", Field: "18446744073709551615u"},
+		{Sample: Float(0.25), Kind: FloatValue, Interface: 0.25, Tag: "0.25", Field: "0.25"},
+		{Sample: FixedFloat(12.5, 2), Kind: FloatValue, Interface: 12.5, Tag: "12.50", Field: "12.50"},
+		{Sample: Bool(true), Kind: BoolValue, Interface: true, Tag: "true", Field: "true"},
+		{Sample: Bool(false), Kind: BoolValue, Interface: false, Tag: "false", Field: "false"},
+		{Sample: String("a b,c=d"), Kind: StringValue, Interface: "a b,c=d", Tag: `a\ b\,c\=d`, Field: `"a b,c=d"`},
+		{Sample: Value{}, Kind: InvalidValue, Interface: nil, Tag: "", Field: ""},
+	}
+
+	for _, testCase := range testCases {
+		v := testCase.Sample
+		if v.Kind() != testCase.Kind {
+			t.Errorf("expected kind %s, got: %s", testCase.Kind, v.Kind())
+		}
+		if v.Interface() != testCase.Interface {
+			t.Errorf("expected: %v, got: %v", testCase.Interface, v.Interface())
+		}
+		if tag, _ := v.appendTo(nil, kindTag, false); testCase.Tag != string(tag) {
+			t.Errorf("expected: %s, got: %s", testCase.Tag, tag)
+		}
+		if field, _ := v.appendTo(nil, kindField, false); testCase.Field != string(field) {
+			t.Errorf("expected: %s, got: %s", testCase.Field, field)
+		}
+	}
+}
+
+// hostName implements ValueMarshaler, its value has spaces to be escaped.
+type hostName struct{ short, domain string }
+
+func (h hostName) MarshalInfluxValue() (Value, error) {
+	if h.short == "" {
+		return Value{}, errors.New("empty host name")
+	}
+	return String(h.short + " at " + h.domain), nil
+}
+
+type TestValueMarshaler struct {
+	Name   string         `influx:",measurement"`
+	Host   hostName       `influx:"host,tag"`
+	Origin hostName       `influx:"origin,field"`
+	Load   Value          `influx:"load,field"`
+	Stats  map[string]any `influx:",fields"`
+	Ts     time.Time      `influx:",timestamp"`
+}
+
+func TestMarshalValue(t *testing.T) {
+	t.Parallel()
+
+	ts := time.Now()
+	v := TestValueMarshaler{
+		Name:   "node",
+		Host:   hostName{"web", "eu"},
+		Origin: hostName{"lb", "us"},
+		Load:   FixedFloat(1, 1),
+		Stats:  map[string]any{"uptime": Duration{Value: "90m", To: time.Hour}, "ok": Bool(true)},
+		Ts:     ts,
+	}
+
+	row, err := Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `node,host=web\ at\ eu origin="lb at us",load=1.0,ok=true,uptime=1.50 ` +
+		strconv.FormatInt(ts.UnixNano(), 10)
+	if expected != string(row) {
+		t.Errorf("expected: %s, got: %s", expected, row)
+	}
+
+	v.Origin = hostName{}
+	if _, err := Marshal(v); !errors.Is(err, ErrMarshalInflux) {
+		t.Errorf("expected ErrMarshalInflux, got: %v", err)
+	}
+
+	v.Origin = hostName{"lb", "us"}
+	v.Load = Value{}
+	if _, err := Marshal(v); !errors.Is(err, ErrEmptyValue) {
+		t.Errorf("expected ErrEmptyValue, got: %v", err)
+	}
+}
+
+func TestUnmarshalValue(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		row      string
+		expected Value
+	}{
+		{`node load=1i`, Int(1)},
+		{`node load=2u`, Uint(2)},
+		{`node load=1.0`, Float(1)},
+		{`node load=1.5`, Float(1.5)},
+		{`node load=t`, Bool(true)},
+		{`node load="high"`, String("high")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.row, func(t *testing.T) {
+			t.Parallel()
+			var v TestValueMarshaler
+			if err := Unmarshal([]byte(tt.row), &v); err != nil {
+				t.Fatal(err)
+			}
+			if v.Load != tt.expected {
+				t.Errorf("expected: %v, got: %v", tt.expected, v.Load)
+			}
+		})
+	}
+}
+
+func TestValueConvert(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		Sample   Value
+		Kind     ValueKind
+		Expected string // empty for lossy conversion
+	}{
+		{Sample: Int(-12), Kind: FloatValue, Expected: "-12"},
+		{Sample: Int(1<<53 + 1), Kind: FloatValue},
+		{Sample: Int(math.MaxInt64), Kind: FloatValue},
+		{Sample: Int(-12), Kind: UintValue},
+		{Sample: Int(12), Kind: UintValue, Expected: "12u"},
+		{Sample: Int(12), Kind: StringValue, Expected: `"12"`},
+		{Sample: Uint(math.MaxUint64), Kind: IntValue},
+		{Sample: Uint(math.MaxUint64), Kind: FloatValue},
+		{Sample: Uint(1 << 63), Kind: FloatValue, Expected: "9223372036854776000"},
+		{Sample: Uint(12), Kind: IntValue, Expected: "12i"},
+		{Sample: Float(12), Kind: IntValue, Expected: "12i"},
+		{Sample: Float(12.5), Kind: IntValue},
+		{Sample: Float(1e19), Kind: IntValue},
+		{Sample: Float(1e19), Kind: UintValue, Expected: "10000000000000000000u"},
+		{Sample: Float(-1), Kind: UintValue},
+		{Sample: Float(math.NaN()), Kind: IntValue},
+		{Sample: float32Value(0.1), Kind: StringValue, Expected: `"0.1"`},
+		{Sample: Bool(true), Kind: IntValue, Expected: "1i"},
+		{Sample: Bool(false), Kind: FloatValue, Expected: "0"},
+		{Sample: Bool(true), Kind: StringValue, Expected: `"true"`},
+		{Sample: String("-12"), Kind: IntValue, Expected: "-12i"},
+		{Sample: String("12.5"), Kind: IntValue},
+		{Sample: String("12.5"), Kind: FloatValue, Expected: "12.5"},
+		{Sample: String("-1"), Kind: UintValue},
+		{Sample: String("abc"), Kind: FloatValue},
+		{Sample: String("true"), Kind: BoolValue},
+		{Sample: Value{}, Kind: IntValue, Expected: ""},
+	}
+
+	for _, testCase := range testCases {
+		v, err := testCase.Sample.convert(testCase.Kind)
+		if testCase.Expected == "" && testCase.Sample.Kind() != InvalidValue {
+			if !errors.Is(err, ErrLossyConversion) {
+				t.Errorf("%s %v to %s: expected ErrLossyConversion, got: %v",
+					testCase.Sample.Kind(), testCase.Sample.Interface(), testCase.Kind, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := v.appendTo(nil, kindField, false); testCase.Expected != string(got) {
+			t.Errorf("expected: %s, got: %s", testCase.Expected, got)
+		}
+	}
+}
+
+type TestCoercion struct {
+	Name    string            `influx:",measurement"`
+	ID      int               `influx:"id,tag,string"`
+	Latency int64             `influx:"latency,field,float"`
+	Count   float64           `influx:"count,field,int"`
+	Size    int               `influx:"size,field,uint"`
+	Code    string            `influx:"code,field,int"`
+	Build   SpecialString     `influx:"build,field,string"`
+	Window  Duration          `influx:"window,field,int"`
+	Extra   map[string]uint32 `influx:"x_,fields,string"`
+	Ts      time.Time         `influx:",timestamp"`
+}
+
+func TestMarshalCoercion(t *testing.T) {
+	t.Parallel()
+
+	ts := time.Now()
+	v := TestCoercion{
+		Name: "http", ID: 12, Latency: 250, Count: 3, Size: 4096, Code: "404",
+		Build: "go,1.23", Window: Duration{Value: "1m", To: time.Millisecond},
+		Extra: map[string]uint32{"retries": 2}, Ts: ts,
+	}
+
+	row, err := Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `http,id=12 latency=250,count=3i,size=4096u,code=404i,build="1.23",window=60000i,x_retries="2" ` +
+		strconv.FormatInt(ts.UnixNano(), 10)
+	if expected != string(row) {
+		t.Errorf("expected: %s, got: %s", expected, row)
+	}
+
+	v.Count = 2.5
+	_, err = Marshal(v)
+	if !errors.Is(err, ErrLossyConversion) {
+		t.Errorf("expected ErrLossyConversion, got: %v", err)
+	}
+	if expected := `influx.TestCoercion.Count: field "count": lossy conversion: float 2.5 to int`; err == nil || expected != err.Error() {
+		t.Errorf("expected: %s, got: %v", expected, err)
+	}
+
+	expected = `http,id=12 latency=250,size=4096u,code=404i,build="1.23",window=60000i,x_retries="2" ` +
+		strconv.FormatInt(ts.UnixNano(), 10)
+	if row := ConvertToInfluxLineProtocol(v); expected != row {
+		t.Errorf("expected: %s, got: %s", expected, row)
+	}
+
+	t.Run("tags", func(t *testing.T) {
+		type numericTag struct {
+			Name  string        `influx:",measurement"`
+			ID    int           `influx:"id,tag"`
+			Delay time.Duration `influx:"delay,tag"`
+			Gauge int           `influx:"g,tag,float"`
+			Count int           `influx:"count,field"`
+		}
+		_, err := Marshal(numericTag{})
+		if expected := "influx.numericTag.Gauge: unsupported type: float option of tag"; !errors.Is(err, ErrUnsupportedType) ||
+			!strings.HasPrefix(err.Error(), expected) {
+			t.Errorf("expected: %s, got: %v", expected, err)
+		}
+
+		type labels struct {
+			Name   string         `influx:",measurement"`
+			Labels map[string]int `influx:",tags,int"`
+		}
+		if _, err := Marshal(labels{}); !errors.Is(err, ErrUnsupportedType) {
+			t.Errorf("expected ErrUnsupportedType, got: %v", err)
+		}
+
+		type plainTag struct {
+			Name  string        `influx:",measurement"`
+			ID    int           `influx:"id,tag"`
+			Size  uint          `influx:"size,tag"`
+			Delay time.Duration `influx:"delay,tag"`
+			Count int           `influx:"count,field"`
+			Ts    time.Time     `influx:",timestamp,omitempty"`
+		}
+		row, err := Marshal(plainTag{Name: "m", ID: 12, Size: 3, Delay: time.Second, Count: 1})
+		if expected := "m,delay=1000000000,id=12,size=3 count=1i"; err != nil || expected != string(row) {
+			t.Errorf("expected: %s, got: %s (%v)", expected, row, err)
+		}
+	})
+
+	t.Run("unmarshal", func(t *testing.T) {
+		type coerced struct {
+			Name    string            `influx:",measurement"`
+			ID      int               `influx:"id,tag,string"`
+			Latency int64             `influx:"latency,field,string"`
+			Count   float64           `influx:"count,field,int"`
+			Size    int               `influx:"size,field,uint"`
+			Code    string            `influx:"code,field,int"`
+			OK      bool              `influx:"ok,field,int"`
+			Window  time.Duration     `influx:"window,field,unit=s,string"`
+			Extra   map[string]uint32 `influx:"x_,fields,string"`
+			Ts      time.Time         `influx:",timestamp"`
+		}
+		v := coerced{
+			Name: "http", ID: 12, Latency: 250, Count: 3, Size: 4096, Code: "404", OK: true,
+			Window: 1500 * time.Millisecond, Extra: map[string]uint32{"retries": 2}, Ts: ts,
+		}
+		row, err := Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := `http,id=12 latency="250",count=3i,size=4096u,code=404i,ok=1i,window="1.5",x_retries="2" ` +
+			strconv.FormatInt(ts.UnixNano(), 10)
+		if expected != string(row) {
+			t.Errorf("expected: %s, got: %s", expected, row)
+		}
+
+		var got coerced
+		if err := Unmarshal(row, &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Name != v.Name || got.ID != v.ID || got.Latency != v.Latency || got.Count != v.Count ||
+			got.Size != v.Size || got.Code != v.Code || got.OK != v.OK || got.Window != v.Window ||
+			got.Extra["retries"] != 2 || !got.Ts.Equal(v.Ts) {
+			t.Errorf("expected: %+v, got: %+v", v, got)
+		}
+
+		err = Unmarshal([]byte(`http latency="2.5"`), &got)
+		if !errors.Is(err, ErrUnmarshalValue) || !errors.Is(err, ErrLossyConversion) {
+			t.Errorf("expected ErrUnmarshalValue and ErrLossyConversion, got: %v", err)
+		}
+	})
+}